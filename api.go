@@ -0,0 +1,267 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiPath is the public, read-only REST API over the catalogue.
+const apiPath = "/api/v1/"
+
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+// apiError is an error with the HTTP status to report it with.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string { return e.message }
+
+// apiEndpoint wraps a read-only API handler with CORS, so other sites can
+// call it from the browser, and ETags, so unchanged results cost only a 304.
+func apiEndpoint(handler func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "If-None-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		switch r.Method {
+		case http.MethodOptions:
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodGet, http.MethodHead:
+		default:
+			w.Header().Set("Allow", "GET, OPTIONS")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		v, err := handler(r)
+		if err != nil {
+			if e, ok := err.(*apiError); ok {
+				writeJSONError(w, e.status, e.message)
+				return
+			}
+			log.Printf("API error for %s: %v", r.URL.Path, err)
+			writeJSONError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		body, ok := v.([]byte)
+		if !ok {
+			if body, err = json.Marshal(v); err != nil {
+				log.Printf("API error for %s: %v", r.URL.Path, err)
+				writeJSONError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			body = append(body, '\n')
+		}
+
+		sum := sha1.Sum(body)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, ignoring
+// the weak marker.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// apiPets serves /api/v1/pets: one page of pets matching the filters, see
+// parsePetQuery.
+func apiPets(r *http.Request) (interface{}, error) {
+	q, err := parsePetQuery(r.URL.Query())
+	if err != nil {
+		return nil, &apiError{http.StatusBadRequest, err.Error()}
+	}
+	pets, total := PetDB.Query(q)
+	return newPetPage(pets, total, q), nil
+}
+
+// apiPet serves /api/v1/pets/{id}.
+func apiPet(r *http.Request) (interface{}, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, apiPath+"pets/"))
+	if err != nil {
+		return nil, &apiError{http.StatusNotFound, "no such pet"}
+	}
+	pet := PetDB.GetPet(id)
+	if pet == nil {
+		return nil, &apiError{http.StatusNotFound, "no such pet"}
+	}
+	return newPetJSON(pet), nil
+}
+
+// apiShelters serves /api/v1/shelters.
+func apiShelters(r *http.Request) (interface{}, error) {
+	return PetDB.Shelters(), nil
+}
+
+// catalogueStats counts the listed pets in a few ways.
+type catalogueStats struct {
+	Total      int            `json:"total"`
+	Shelters   int            `json:"shelters"`
+	WithPhoto  int            `json:"withPhoto"`
+	ByKind     map[string]int `json:"byKind"`
+	BySex      map[string]int `json:"bySex"`
+	ByBodyType map[string]int `json:"byBodyType"`
+	ByAge      map[string]int `json:"byAge"`
+	ByCounty   map[string]int `json:"byCounty"`
+}
+
+// apiStats serves /api/v1/stats.
+func apiStats(r *http.Request) (interface{}, error) {
+	stats := catalogueStats{
+		ByKind:     make(map[string]int),
+		BySex:      make(map[string]int),
+		ByBodyType: make(map[string]int),
+		ByAge:      make(map[string]int),
+		ByCounty:   make(map[string]int),
+	}
+	for _, pet := range PetDB.All() {
+		view := newPetView(pet, "", "")
+		stats.Total++
+		if pet.ImageName != "" && photos.Quality(pet) != photoMissing {
+			stats.WithPhoto++
+		}
+		stats.ByKind[orUnknown(view.Kind)]++
+		stats.BySex[orUnknown(view.Sex)]++
+		stats.ByBodyType[orUnknown(view.BodyType)]++
+		stats.ByAge[orUnknown(view.Age)]++
+		stats.ByCounty[orUnknown(cityFromAddress(pet.ShelterAddress))]++
+	}
+	stats.Shelters = len(PetDB.Shelters())
+	return stats, nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "不詳"
+	}
+	return s
+}
+
+// apiSpec serves /api/v1/openapi.json.
+func apiSpec(r *http.Request) (interface{}, error) {
+	return openAPISpec, nil
+}
+
+// petJSON is a pet as returned by the JSON APIs, with the open data's codes
+// replaced by words.
+type petJSON struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	Sex        string `json:"sex"`
+	BodyType   string `json:"bodyType"`
+	Color      string `json:"color"`
+	Age        string `json:"age"`
+	Status     string `json:"status"`
+	Sterilized string `json:"sterilized"`
+	Vaccinated string `json:"vaccinated"`
+	FoundPlace string `json:"foundPlace,omitempty"`
+	IntakeDate string `json:"intakeDate,omitempty"`
+	OpenDate   string `json:"openDate,omitempty"`
+	Remark     string `json:"remark,omitempty"`
+	Shelter    string `json:"shelter"`
+	Address    string `json:"address"`
+	Phone      string `json:"phone"`
+	ImageURL   string `json:"imageUrl"`
+	PreviewURL string `json:"previewUrl"`
+	PageURL    string `json:"pageUrl,omitempty"`
+	Favorite   *bool  `json:"favorite,omitempty"`
+}
+
+func newPetJSON(pet *Pet) petJSON {
+	view := newPetView(pet, petImageURL(pet, heroVariant), "")
+	return petJSON{
+		ID:         view.ID,
+		Name:       view.Name,
+		Kind:       view.Kind,
+		Sex:        view.Sex,
+		BodyType:   view.BodyType,
+		Color:      view.Color,
+		Age:        view.Age,
+		Status:     view.Status,
+		Sterilized: view.Sterilized,
+		Vaccinated: view.Vaccinated,
+		FoundPlace: view.FoundPlace,
+		IntakeDate: view.IntakeDate,
+		OpenDate:   view.OpenDate,
+		Remark:     view.Remark,
+		Shelter:    view.ShelterName,
+		Address:    view.ShelterAddress,
+		Phone:      view.Phone,
+		ImageURL:   view.ImageURL,
+		PreviewURL: petImageURL(pet, previewVariant),
+		PageURL:    view.PageURL,
+	}
+}
+
+// petPage is one page of a pet listing. Next is the offset of the next page,
+// or nil on the last one.
+type petPage struct {
+	Pets  []petJSON `json:"pets"`
+	Total int       `json:"total"`
+	Next  *int      `json:"next"`
+}
+
+func newPetPage(pets []*Pet, total int, q PetQuery) petPage {
+	page := petPage{Pets: make([]petJSON, 0, len(pets)), Total: total}
+	for _, pet := range pets {
+		page.Pets = append(page.Pets, newPetJSON(pet))
+	}
+	if next := q.Offset + len(pets); len(pets) > 0 && next < total {
+		page.Next = &next
+	}
+	return page
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
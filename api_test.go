@@ -0,0 +1,157 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func apiRequest(t *testing.T, handler func(*http.Request) (interface{}, error), method, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	apiEndpoint(handler)(rec, req)
+	return rec
+}
+
+func TestAPIPets(t *testing.T) {
+	withQueryPets(t)
+
+	rec := apiRequest(t, apiPets, http.MethodGet, "/api/v1/pets?county=臺中市&limit=1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected CORS to be open, got %q", got)
+	}
+	var page petPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Pets) != 1 || page.Next == nil || *page.Next != 1 || page.Pets[0].Favorite != nil {
+		t.Errorf("Unexpected page %+v", page)
+	}
+
+	if rec := apiRequest(t, apiPets, http.MethodGet, "/api/v1/pets?limit=ten", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bad limit to be rejected, got %d", rec.Code)
+	}
+	if rec := apiRequest(t, apiPets, http.MethodPost, "/api/v1/pets", nil); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestAPIPet(t *testing.T) {
+	withQueryPets(t)
+
+	rec := apiRequest(t, apiPet, http.MethodGet, "/api/v1/pets/3", nil)
+	var pet petJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &pet); err != nil {
+		t.Fatal(err)
+	}
+	if pet.ID != 3 || pet.Kind != "貓" || pet.BodyType != "小型" {
+		t.Errorf("Unexpected pet %+v", pet)
+	}
+	for _, path := range []string{"/api/v1/pets/99", "/api/v1/pets/x"} {
+		if rec := apiRequest(t, apiPet, http.MethodGet, path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected %s to be 404, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestAPIETag(t *testing.T) {
+	withQueryPets(t)
+
+	rec := apiRequest(t, apiShelters, http.MethodGet, "/api/v1/shelters", nil)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected a tagged response, got %d %q", rec.Code, etag)
+	}
+	rec = apiRequest(t, apiShelters, http.MethodGet, "/api/v1/shelters", http.Header{"If-None-Match": {`"other", W/` + etag}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("Expected 304 for a matching ETag, got %d", rec.Code)
+	}
+
+	// A change to the catalogue changes the tag.
+	PetDB.LoadPets(TaiwanPets{{AnimalID: 5, AnimalKind: "貓", ShelterName: "新北市板橋區公立動物之家", ShelterAddress: "新北市板橋區板城路28-1號"}})
+	rec = apiRequest(t, apiShelters, http.MethodGet, "/api/v1/shelters", http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("Expected a new ETag after a refresh, got %d", rec.Code)
+	}
+}
+
+func TestAPISheltersEmpty(t *testing.T) {
+	saved := PetDB
+	defer func() { PetDB = saved }()
+	PetDB = new(Pets)
+	PetDB.LoadPets(TaiwanPets{})
+
+	rec := apiRequest(t, apiShelters, http.MethodGet, "/api/v1/shelters", nil)
+	if got := strings.TrimSpace(rec.Body.String()); got != "[]" {
+		t.Errorf("Expected an empty array, got %s", got)
+	}
+}
+
+func TestAPIPreflight(t *testing.T) {
+	rec := apiRequest(t, apiStats, http.MethodOptions, "/api/v1/stats", http.Header{"Origin": {"https://example.org"}})
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("got status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, OPTIONS" {
+		t.Errorf("Unexpected allowed methods %q", got)
+	}
+}
+
+func TestAPIStats(t *testing.T) {
+	withQueryPets(t)
+
+	rec := apiRequest(t, apiStats, http.MethodGet, "/api/v1/stats", nil)
+	var stats catalogueStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 4 || stats.Shelters != 2 || stats.WithPhoto != 0 {
+		t.Errorf("Unexpected totals %+v", stats)
+	}
+	if stats.ByKind["狗"] != 3 || stats.ByKind["貓"] != 1 {
+		t.Errorf("Unexpected kinds %v", stats.ByKind)
+	}
+	if stats.ByCounty["臺北市"] != 2 || stats.ByCounty["臺中市"] != 2 {
+		t.Errorf("Unexpected counties %v", stats.ByCounty)
+	}
+	if stats.BySex["母"] != 3 || stats.ByBodyType["小型"] != 2 || stats.ByAge["幼年"] != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestAPISpec(t *testing.T) {
+	rec := apiRequest(t, apiSpec, http.MethodGet, "/api/v1/openapi.json", nil)
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("The spec is not valid JSON: %v", err)
+	}
+	for _, path := range []string{"/pets", "/pets/{id}", "/shelters", "/stats"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("The spec does not describe %s", path)
+		}
+	}
+}
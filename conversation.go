@@ -0,0 +1,247 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// criteriaAny marks a criterion the user explicitly wants to drop, e.g. "不限性別".
+const criteriaAny = "不限"
+
+// conversationTTL is how long a user's search criteria are remembered.
+const conversationTTL = 30 * time.Minute
+
+// resetCommand clears the remembered search criteria.
+const resetCommand = "重新搜尋"
+
+// conversations holds the per-user search state.
+var conversations = newConversationStore(conversationTTL)
+
+// Conversation is the search state remembered for a single user.
+type Conversation struct {
+	Criteria  *SearchCriteria
+	PetID     int // The pet last shown to the user, 0 if none.
+	UpdatedAt time.Time
+}
+
+type conversationStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*Conversation
+}
+
+func newConversationStore(ttl time.Duration) *conversationStore {
+	return &conversationStore{
+		ttl:      ttl,
+		sessions: make(map[string]*Conversation),
+	}
+}
+
+// Criteria returns a copy of the user's last criteria, or nil if none are remembered.
+func (s *conversationStore) Criteria(userID string) *SearchCriteria {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv := s.get(userID)
+	if conv == nil || conv.Criteria == nil {
+		return nil
+	}
+	c := *conv.Criteria
+	return &c
+}
+
+// SetCriteria remembers criteria as the user's current search.
+func (s *conversationStore) SetCriteria(userID string, criteria *SearchCriteria) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *criteria
+	conv := s.touch(userID)
+	conv.Criteria = &c
+}
+
+// SelectedPet returns the ID of the pet last shown to the user, or 0.
+func (s *conversationStore) SelectedPet(userID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv := s.get(userID)
+	if conv == nil {
+		return 0
+	}
+	return conv.PetID
+}
+
+// SelectPet remembers the pet the user is currently looking at.
+func (s *conversationStore) SelectPet(userID string, petID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch(userID).PetID = petID
+}
+
+// Reset forgets the user's search criteria.
+func (s *conversationStore) Reset(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if conv := s.get(userID); conv != nil {
+		conv.Criteria = nil
+	}
+}
+
+// Forget drops everything remembered about the user.
+func (s *conversationStore) Forget(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, userID)
+}
+
+// get returns the user's conversation if it has not expired. s.mu must be held.
+func (s *conversationStore) get(userID string) *Conversation {
+	conv, ok := s.sessions[userID]
+	if !ok {
+		return nil
+	}
+	if time.Since(conv.UpdatedAt) > s.ttl {
+		delete(s.sessions, userID)
+		return nil
+	}
+	return conv
+}
+
+// touch returns the user's conversation, creating it if needed, and marks it
+// as updated. s.mu must be held.
+func (s *conversationStore) touch(userID string) *Conversation {
+	conv := s.get(userID)
+	if conv == nil {
+		conv = &Conversation{}
+		s.sessions[userID] = conv
+	}
+	conv.UpdatedAt = time.Now()
+	return conv
+}
+
+// refineSearch parses text as a refinement of the user's previous search and
+// remembers the combined criteria. It returns nil if text is not a search.
+func refineSearch(ctx context.Context, userID, text string) (*SearchCriteria, error) {
+	previous := conversations.Criteria(userID)
+
+	update, err := ParseSearchCriteriaFromQuery(ctx, text, previous)
+	if err != nil {
+		return nil, err
+	}
+	update = update.Overlay(parseLocalRefinements(text))
+	if update.IsEmpty() {
+		return nil, nil
+	}
+
+	next := previous.Apply(update)
+	if next.IsEmpty() {
+		conversations.Reset(userID)
+		return nil, nil
+	}
+	conversations.SetCriteria(userID, next)
+	return next, nil
+}
+
+// IsEmpty reports whether no criterion is set.
+func (c *SearchCriteria) IsEmpty() bool {
+	if c == nil {
+		return true
+	}
+	for _, f := range c.fields() {
+		if *f != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns a copy of c refined by update: set fields override, fields set
+// to criteriaAny are cleared and empty fields are kept from c.
+func (c *SearchCriteria) Apply(update *SearchCriteria) *SearchCriteria {
+	next := &SearchCriteria{}
+	if c != nil {
+		*next = *c
+	}
+	if update == nil {
+		return next
+	}
+	dst, src := next.fields(), update.fields()
+	for i := range dst {
+		switch *src[i] {
+		case "":
+		case criteriaAny:
+			*dst[i] = ""
+		default:
+			*dst[i] = *src[i]
+		}
+	}
+	return next
+}
+
+// Overlay combines two updates, preferring the fields set in other.
+func (c *SearchCriteria) Overlay(other *SearchCriteria) *SearchCriteria {
+	next := &SearchCriteria{}
+	if c != nil {
+		*next = *c
+	}
+	if other == nil {
+		return next
+	}
+	dst, src := next.fields(), other.fields()
+	for i := range dst {
+		if *src[i] != "" {
+			*dst[i] = *src[i]
+		}
+	}
+	return next
+}
+
+func (c *SearchCriteria) fields() []*string {
+	return []*string{&c.Kind, &c.Sex, &c.BodyType, &c.Age, &c.Color, &c.Pattern, &c.Location}
+}
+
+// parseLocalRefinements recognises the common refinement phrases without
+// calling Gemini, so clearing or switching criteria always works.
+func parseLocalRefinements(text string) *SearchCriteria {
+	c := &SearchCriteria{}
+	clears := map[*string][]string{
+		&c.Kind:     {"不限種類"},
+		&c.Sex:      {"不限性別", "公母都可以", "公母都行"},
+		&c.BodyType: {"不限體型", "不限大小"},
+		&c.Age:      {"不限年紀", "不限年齡"},
+		&c.Color:    {"不限顏色", "不限毛色"},
+		&c.Pattern:  {"不限花紋"},
+		&c.Location: {"不限地區", "不限縣市"},
+	}
+	for field, phrases := range clears {
+		for _, phrase := range phrases {
+			if strings.Contains(text, phrase) {
+				*field = criteriaAny
+			}
+		}
+	}
+
+	for _, kind := range []string{"貓", "狗"} {
+		for _, verb := range []string{"換成", "改成", "換", "改找"} {
+			if strings.Contains(text, verb+kind) {
+				c.Kind = kind
+			}
+		}
+	}
+	return c
+}
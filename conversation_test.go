@@ -0,0 +1,98 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchCriteriaApply(t *testing.T) {
+	previous := &SearchCriteria{Kind: "狗", Sex: "母"}
+
+	next := previous.Apply(&SearchCriteria{BodyType: "小型"})
+	if next.Kind != "狗" || next.Sex != "母" || next.BodyType != "小型" {
+		t.Errorf("Expected merged criteria, got %+v", next)
+	}
+
+	next = next.Apply(&SearchCriteria{Kind: "貓", Sex: criteriaAny})
+	if next.Kind != "貓" || next.Sex != "" || next.BodyType != "小型" {
+		t.Errorf("Expected overridden and cleared criteria, got %+v", next)
+	}
+
+	if previous.Kind != "狗" || previous.BodyType != "" {
+		t.Errorf("Apply must not modify the receiver, got %+v", previous)
+	}
+}
+
+func TestParseLocalRefinements(t *testing.T) {
+	c := parseLocalRefinements("不限性別，換成貓")
+	if c.Sex != criteriaAny {
+		t.Errorf("Expected sex to be cleared, got %q", c.Sex)
+	}
+	if c.Kind != "貓" {
+		t.Errorf("Expected kind 貓, got %q", c.Kind)
+	}
+
+	if c := parseLocalRefinements("今天天氣很好"); !c.IsEmpty() {
+		t.Errorf("Expected no refinements, got %+v", c)
+	}
+}
+
+func TestConversationStore(t *testing.T) {
+	s := newConversationStore(time.Minute)
+	if c := s.Criteria("u1"); c != nil {
+		t.Fatalf("Expected no criteria, got %+v", c)
+	}
+
+	s.SetCriteria("u1", &SearchCriteria{Kind: "狗"})
+	c := s.Criteria("u1")
+	if c == nil || c.Kind != "狗" {
+		t.Fatalf("Expected remembered criteria, got %+v", c)
+	}
+	c.Kind = "貓"
+	if s.Criteria("u1").Kind != "狗" {
+		t.Error("Criteria must return a copy")
+	}
+
+	s.SelectPet("u1", 42)
+	s.Reset("u1")
+	if c := s.Criteria("u1"); c != nil {
+		t.Errorf("Expected criteria to be reset, got %+v", c)
+	}
+	if id := s.SelectedPet("u1"); id != 42 {
+		t.Errorf("Expected the selected pet to survive a reset, got %d", id)
+	}
+
+	expired := newConversationStore(0)
+	expired.SetCriteria("u1", &SearchCriteria{Kind: "狗"})
+	time.Sleep(time.Millisecond)
+	if c := expired.Criteria("u1"); c != nil {
+		t.Errorf("Expected criteria to expire, got %+v", c)
+	}
+}
+
+func TestConversationForget(t *testing.T) {
+	s := newConversationStore(time.Hour)
+	s.SetCriteria("u1", &SearchCriteria{Kind: "狗"})
+	s.SelectPet("u1", 42)
+	s.SelectPet("u2", 7)
+
+	s.Forget("u1")
+	if c, id := s.Criteria("u1"), s.SelectedPet("u1"); c != nil || id != 0 {
+		t.Errorf("Expected nothing to be remembered, got %+v %d", c, id)
+	}
+	if id := s.SelectedPet("u2"); id != 7 {
+		t.Errorf("Forgetting must not affect other users, got %d", id)
+	}
+}
@@ -0,0 +1,163 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"text/template"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	petBubbleTemplate         = "pet_bubble.json.tmpl"
+	detailBubbleTemplate      = "detail_bubble.json.tmpl"
+	unavailableBubbleTemplate = "unavailable_bubble.json.tmpl"
+)
+
+// flexTemplateNames are the templates the bot renders; each must exist and
+// produce a valid bubble.
+var flexTemplateNames = []string{petBubbleTemplate, detailBubbleTemplate, unavailableBubbleTemplate}
+
+//go:embed flex/*.json.tmpl
+var defaultFlexTemplates embed.FS
+
+// flexTemplates holds the flex bubble templates, loaded by
+// initializeFlexTemplates. They are JSON with text/template placeholders
+// filled from flexBubbleData.
+var flexTemplates *template.Template
+
+// flexBubbleData is what a bubble template is rendered with.
+type flexBubbleData struct {
+	PetView
+	// PrimaryButton is the JSON of the card's main button, which depends on
+	// where the card is shown.
+	PrimaryButton    string
+	MoreLikeThisData string
+	ShelterInfoData  string
+	DetailsData      string
+	ShareURI         string
+}
+
+// detailRow is a labelled value on a card, passed to the "row" template.
+type detailRow struct {
+	Title, Value string
+}
+
+func newFlexBubbleData(pet PetView, primaryButton *linebot.ButtonComponent) (flexBubbleData, error) {
+	button, err := json.Marshal(primaryButton)
+	if err != nil {
+		return flexBubbleData{}, err
+	}
+	return flexBubbleData{
+		PetView:          pet,
+		PrimaryButton:    string(button),
+		MoreLikeThisData: Postback{Action: postbackMoreLikeThis, PetID: pet.ID}.Encode(),
+		ShelterInfoData:  Postback{Action: postbackShelterInfo, PetID: pet.ID}.Encode(),
+		DetailsData:      Postback{Action: postbackDetails, PetID: pet.ID}.Encode(),
+		ShareURI:         shareURI(pet),
+	}, nil
+}
+
+var flexTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"row": func(title, value string) detailRow {
+		if value == "" {
+			value = "不詳"
+		}
+		return detailRow{Title: title, Value: value}
+	},
+}
+
+// initializeFlexTemplates loads the embedded templates, with any found in
+// FLEX_TEMPLATE_DIR replacing those of the same name, so the cards can be
+// restyled without a rebuild.
+func initializeFlexTemplates() error {
+	embedded, err := fs.Sub(defaultFlexTemplates, "flex")
+	if err != nil {
+		return err
+	}
+	var override fs.FS
+	if dir := os.Getenv("FLEX_TEMPLATE_DIR"); dir != "" {
+		override = os.DirFS(dir)
+	}
+	t, err := loadFlexTemplates(embedded, override)
+	if err != nil {
+		return err
+	}
+	flexTemplates = t
+	return nil
+}
+
+// loadFlexTemplates parses the templates in base and then override, which may
+// be nil, and checks that each renders a valid bubble.
+func loadFlexTemplates(base, override fs.FS) (*template.Template, error) {
+	t, err := template.New("flex").Funcs(flexTemplateFuncs).ParseFS(base, "*.json.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	if override != nil {
+		if matches, _ := fs.Glob(override, "*.json.tmpl"); len(matches) > 0 {
+			if t, err = t.ParseFS(override, matches...); err != nil {
+				return nil, err
+			}
+			log.Printf("Loaded flex templates %v", matches)
+		}
+	}
+
+	sample := newPetView(&Pet{ID: 1, Name: "範例", Variety: "狗", Sex: "M", Phone: "02-12345678", ShelterAddress: "臺北市", AcceptNum: "A1"}, placeholderImageURL, "範例介紹")
+	for _, name := range flexTemplateNames {
+		for _, profile := range []string{sample.Profile, ""} {
+			sample.Profile = profile
+			data, err := newFlexBubbleData(sample, createFavoriteButton(&Pet{ID: sample.ID}))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := renderBubble(t, name, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// renderBubble fills in the named template and parses the result as a flex
+// bubble.
+func renderBubble(t *template.Template, name string, data flexBubbleData) (*linebot.BubbleContainer, error) {
+	if t == nil {
+		return nil, errors.New("flex templates are not loaded")
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	container, err := linebot.UnmarshalFlexMessageJSON(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("template %s is not a valid flex message: %w", name, err)
+	}
+	bubble, ok := container.(*linebot.BubbleContainer)
+	if !ok {
+		return nil, fmt.Errorf("template %s is not a bubble", name)
+	}
+	return bubble, nil
+}
@@ -0,0 +1,131 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenPet has a profile, empty fields and characters that need escaping.
+var goldenPet = newPetView(&Pet{
+	ID:              42,
+	Name:            "小花 \"Flower\"",
+	Variety:         "貓",
+	Sex:             "F",
+	Type:            "SMALL",
+	HairType:        "三花色",
+	AcceptNum:       "A11302150001",
+	IsSterilization: "T",
+	Bacterin:        "F",
+	FoundPlace:      "內湖區成功路",
+	IntakeDate:      "2024/02/15",
+	OpenDate:        "2024/02/29",
+	Note:            "怕生，需要耐心 & 時間",
+	Status:          "OPEN",
+	Resettlement:    "臺北市動物之家(臺北市內湖區潭美街852號)",
+	ShelterName:     "臺北市動物之家",
+	ShelterAddress:  "臺北市內湖區潭美街852號",
+	Phone:           "02-87913254、02-87913255",
+}, "https://bot.example/img/42", "親人又愛撒嬌的三花貓 <3")
+
+// TestFlexTemplatesGolden renders every template and compares the bubble
+// sent to LINE with testdata/flex. Run with -update after changing a template.
+func TestFlexTemplatesGolden(t *testing.T) {
+	for _, name := range flexTemplateNames {
+		t.Run(name, func(t *testing.T) {
+			data, err := newFlexBubbleData(goldenPet, createFavoriteButton(&Pet{ID: goldenPet.ID}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bubble, err := renderBubble(flexTemplates, name, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.MarshalIndent(bubble, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", "flex", strings.TrimSuffix(name, ".tmpl")+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s does not match %s:\n%s", name, path, got)
+			}
+		})
+	}
+}
+
+func TestLoadFlexTemplatesOverride(t *testing.T) {
+	base := fstest.MapFS{}
+	for _, name := range flexTemplateNames {
+		data, err := defaultFlexTemplates.ReadFile("flex/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base[name] = &fstest.MapFile{Data: data}
+	}
+
+	override := fstest.MapFS{
+		unavailableBubbleTemplate: &fstest.MapFile{Data: []byte(`{"type": "bubble", "body": {"type": "box", "layout": "vertical", "contents": [{"type": "text", "text": "再見了"}, {{.PrimaryButton}}]}}`)},
+	}
+	tmpl, err := loadFlexTemplates(base, override)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := newFlexBubbleData(goldenPet, createRemoveFavoriteButton(&Pet{ID: goldenPet.ID}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bubble, err := renderBubble(tmpl, unavailableBubbleTemplate, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := bubble.Body.Contents[0].(*linebot.TextComponent).Text; text != "再見了" {
+		t.Errorf("Expected the override to be used, got %q", text)
+	}
+	if _, err := renderBubble(tmpl, petBubbleTemplate, data); err != nil {
+		t.Errorf("Expected the embedded pet bubble to be kept: %v", err)
+	}
+
+	for name, src := range map[string]string{
+		"syntax":   `{"type": "bubble", {{.Nope`,
+		"field":    `{"type": "bubble", "body": {{json .Missing}}}`,
+		"not flex": `{"type": "carousel", "contents": []}`,
+		"json":     `{"type": "bubble",`,
+	} {
+		bad := fstest.MapFS{petBubbleTemplate: &fstest.MapFile{Data: []byte(src)}}
+		if _, err := loadFlexTemplates(base, bad); err == nil {
+			t.Errorf("Expected the %s error to be caught when loading", name)
+		}
+	}
+}
@@ -15,9 +15,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/option"
@@ -30,9 +33,119 @@ type SearchCriteria struct {
 	BodyType string `json:"body_type,omitempty"`
 	Age      string `json:"age,omitempty"`
 	Color    string `json:"color,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	Location string `json:"location,omitempty"`
 }
 
-var genaiClient *genai.GenerativeModel
+// errGeminiQuota is returned when a Gemini call would exceed a quota.
+var errGeminiQuota = errors.New("gemini quota exceeded")
+
+// GeminiConfig holds the Gemini settings read from the environment.
+type GeminiConfig struct {
+	Model       string        // GEMINI_MODEL
+	Temperature *float32      // GEMINI_TEMPERATURE, model default if unset
+	Timeout     time.Duration // GEMINI_TIMEOUT, per request
+	UserRPM     int           // GEMINI_USER_RPM, requests per user per minute, 0 is unlimited
+	GlobalRPM   int           // GEMINI_GLOBAL_RPM, requests per minute, 0 is unlimited
+}
+
+// loadGeminiConfig reads the Gemini settings, keeping the defaults for any
+// value that is missing or invalid.
+func loadGeminiConfig() GeminiConfig {
+	conf := GeminiConfig{
+		Model:     "gemini-1.5-flash",
+		Timeout:   15 * time.Second,
+		UserRPM:   10,
+		GlobalRPM: 0,
+	}
+	if v := os.Getenv("GEMINI_MODEL"); v != "" {
+		conf.Model = v
+	}
+	if v := os.Getenv("GEMINI_TEMPERATURE"); v != "" {
+		if t, err := strconv.ParseFloat(v, 32); err == nil {
+			temp := float32(t)
+			conf.Temperature = &temp
+		} else {
+			log.Printf("Warning: invalid GEMINI_TEMPERATURE %q: %v", v, err)
+		}
+	}
+	if v := os.Getenv("GEMINI_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			conf.Timeout = d
+		} else {
+			log.Printf("Warning: invalid GEMINI_TIMEOUT %q: %v", v, err)
+		}
+	}
+	for env, dst := range map[string]*int{"GEMINI_USER_RPM": &conf.UserRPM, "GEMINI_GLOBAL_RPM": &conf.GlobalRPM} {
+		if v := os.Getenv(env); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				*dst = n
+			} else {
+				log.Printf("Warning: invalid %s %q: %v", env, v, err)
+			}
+		}
+	}
+	return conf
+}
+
+// geminiModel wraps a Gemini model with the configured timeout and quotas.
+type geminiModel struct {
+	model       *genai.GenerativeModel
+	timeout     time.Duration
+	userQuota   *rateLimiter
+	globalQuota *rateLimiter
+}
+
+func newGeminiModel(client *genai.Client, conf GeminiConfig) *geminiModel {
+	model := client.GenerativeModel(conf.Model)
+	if conf.Temperature != nil {
+		model.SetTemperature(*conf.Temperature)
+	}
+	return &geminiModel{
+		model:       model,
+		timeout:     conf.Timeout,
+		userQuota:   newRateLimiter(conf.UserRPM, time.Minute),
+		globalQuota: newRateLimiter(conf.GlobalRPM, time.Minute),
+	}
+}
+
+// GenerateContent calls Gemini within the quotas of the user in ctx. The call
+// is aborted when ctx is cancelled or the timeout expires.
+func (m *geminiModel) GenerateContent(ctx context.Context, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	if !m.allowCall(ctx) {
+		return nil, errGeminiQuota
+	}
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	return m.model.GenerateContent(ctx, parts...)
+}
+
+// allowCall reports whether a call fits in both quotas. The global quota is
+// checked first so a call rejected there doesn't use up the user's quota.
+func (m *geminiModel) allowCall(ctx context.Context) bool {
+	if !m.globalQuota.Allow("") {
+		return false
+	}
+	userID := userFromContext(ctx)
+	return userID == "" || m.userQuota.Allow(userID)
+}
+
+type userContextKey struct{}
+
+// contextWithUser tags ctx with the LINE user the Gemini calls are made for.
+func contextWithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userContextKey{}, userID)
+}
+
+func userFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userContextKey{}).(string)
+	return userID
+}
+
+var genaiClient *geminiModel
 
 func init() {
 	apiKey := os.Getenv("GOOGLE_API_KEY")
@@ -46,11 +159,21 @@ func init() {
 	if err != nil {
 		log.Fatalf("Failed to create genai client: %v", err)
 	}
-	genaiClient = client.GenerativeModel("gemini-1.5-flash")
+	conf := loadGeminiConfig()
+	log.Printf("Gemini model %s, timeout %s, quotas %d/user/min %d/min", conf.Model, conf.Timeout, conf.UserRPM, conf.GlobalRPM)
+	genaiClient = newGeminiModel(client, conf)
+	petVision = &geminiVision{model: genaiClient}
+	questionAnswerer = &geminiAnswerer{model: genaiClient}
+
+	if enabled, _ := strconv.ParseBool(os.Getenv("PET_PROFILES")); enabled {
+		petProfiles = newProfileCache(&geminiProfileGenerator{model: genaiClient})
+	}
 }
 
-// ParseSearchCriteriaFromQuery uses Gemini to parse the user's query.
-func ParseSearchCriteriaFromQuery(query string) (*SearchCriteria, error) {
+// ParseSearchCriteriaFromQuery uses Gemini to parse the user's query. When
+// previous is set, the query is treated as a refinement of it and only the
+// changed criteria are returned, with dropped ones set to criteriaAny.
+func ParseSearchCriteriaFromQuery(ctx context.Context, query string, previous *SearchCriteria) (*SearchCriteria, error) {
 	if genaiClient == nil {
 		return nil, nil // Gemini is not initialized
 	}
@@ -65,6 +188,8 @@ Based on the request, identify the following criteria:
 - body_type: "小型", "中型", or "大型"
 - age: "幼年", "成年"
 - color: "白", "黑", "黃", "棕", "灰", "虎斑", "三花", "其他"
+- pattern: "虎斑", "三花", "斑點", "雙色"
+- location: the city or county, e.g. "臺北市", "新北市", "臺中市"
 
 Return the criteria as a JSON object. If a criterion is not mentioned, omit it from the JSON.
 For example, if the user says "我想找一隻小隻的母狗", you should return:
@@ -79,37 +204,63 @@ If the user says "有貓嗎", you should return:
 }
 If the user's query is not related to finding a pet, return an empty JSON object {}.
 `
+	if !previous.IsEmpty() {
+		prevJSON, _ := json.Marshal(previous)
+		prompt += `
+The user is refining a previous search with these criteria: ` + string(prevJSON) + `
+Only return the criteria that the new request adds or changes; do not repeat unchanged ones.
+If the user removes a criterion (e.g. "不限性別"), set it to "` + criteriaAny + `".
+For example, if the previous criteria are {"kind": "狗"} and the user says "要小隻的", you should return:
+{
+  "body_type": "小型"
+}
+If the user says "換成貓", you should return:
+{
+  "kind": "貓"
+}
+`
+	}
 
-	ctx := context.Background()
 	resp, err := genaiClient.GenerateContent(ctx, genai.Text(prompt))
 	if err != nil {
 		return nil, err
 	}
 
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return nil, nil
-	}
-
-	jsonString := ""
-	if part, ok := resp.Candidates[0].Content.Parts[0].(genai.Text); ok {
-		jsonString = string(part)
-	}
+	return parseCriteriaResponse(resp), nil
+}
 
+// parseCriteriaResponse decodes the JSON criteria in a Gemini response. It
+// returns nil if the response holds no usable criteria.
+func parseCriteriaResponse(resp *genai.GenerateContentResponse) *SearchCriteria {
 	// Clean the JSON string
-	jsonString = cleanJSONString(jsonString)
+	jsonString := cleanJSONString(responseText(resp))
+	if jsonString == "" {
+		return nil
+	}
 
 	var criteria SearchCriteria
 	if err := json.Unmarshal([]byte(jsonString), &criteria); err != nil {
 		log.Printf("Failed to unmarshal JSON from Gemini: %v, raw: %s", err, jsonString)
-		return nil, nil // Could not parse, treat as no criteria
+		return nil // Could not parse, treat as no criteria
 	}
 
 	// If all fields are empty, it means no criteria were found.
-	if criteria.Kind == "" && criteria.Sex == "" && criteria.BodyType == "" && criteria.Age == "" {
-		return nil, nil
+	if criteria.IsEmpty() {
+		return nil
 	}
 
-	return &criteria, nil
+	return &criteria
+}
+
+// responseText returns the text of the first candidate in a Gemini response.
+func responseText(resp *genai.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
+		return ""
+	}
+	if part, ok := resp.Candidates[0].Content.Parts[0].(genai.Text); ok {
+		return string(part)
+	}
+	return ""
 }
 
 func cleanJSONString(s string) string {
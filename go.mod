@@ -5,7 +5,10 @@ go 1.23.0
 toolchain go1.24.3
 
 require (
+	firebase.google.com/go/v4 v4.16.1
 	github.com/line/line-bot-sdk-go/v7 v7.21.0
+	github.com/mattn/go-sqlite3 v1.14.32
+	golang.org/x/sync v0.15.0
 	google.golang.org/api v0.240.0
 )
 
@@ -15,7 +18,6 @@ require (
 	cloud.google.com/go/iam v1.5.2 // indirect
 	cloud.google.com/go/monitoring v1.24.2 // indirect
 	cloud.google.com/go/storage v1.55.0 // indirect
-	firebase.google.com/go/v4 v4.16.1 // indirect
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/detectors/gcp v1.27.0 // indirect
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/metric v0.51.0 // indirect
 	github.com/GoogleCloudPlatform/opentelemetry-operations-go/internal/resourcemapping v0.51.0 // indirect
@@ -61,7 +63,6 @@ require (
 	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/net v0.41.0 // indirect
 	golang.org/x/oauth2 v0.30.0 // indirect
-	golang.org/x/sync v0.15.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.26.0 // indirect
 	golang.org/x/time v0.12.0 // indirect
@@ -0,0 +1,169 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	shortlistCommand      = "清單"
+	clearShortlistCommand = "清空清單"
+	// groupSearchPrefix marks a search in groups, where the bot otherwise
+	// stays quiet so it does not answer every message.
+	groupSearchPrefix = "找"
+)
+
+// chatID returns the GroupID or RoomID of a shared chat, or "" for a
+// one-on-one chat.
+func chatID(source *linebot.EventSource) string {
+	if source.GroupID != "" {
+		return source.GroupID
+	}
+	return source.RoomID
+}
+
+// groupSuggestions are the quick replies shown in groups.
+func groupSuggestions() *linebot.QuickReplyItems {
+	return newQuickReplies().Message("看狗狗", "狗").Message("看貓咪", "貓").Message("群組清單", shortlistCommand).Build()
+}
+
+// handleGroupText handles a message in a group or room. Searches and shown
+// pets are remembered per group, and cards add to the group shortlist.
+func handleGroupText(ctx context.Context, replyToken, groupID, text string) error {
+	switch {
+	case text == "狗" || text == "dog":
+		return replyWithGroupPet(ctx, replyToken, PetDB.GetNextDog())
+	case text == "貓" || text == "cat":
+		return replyWithGroupPet(ctx, replyToken, PetDB.GetNextCat())
+	case text == shortlistCommand:
+		return handleShowShortlist(ctx, replyToken, groupID)
+	case text == clearShortlistCommand:
+		return handleClearShortlist(ctx, replyToken, groupID)
+	case text == helpCommand:
+		return replyWithText(replyToken, joinMessage)
+	case strings.HasPrefix(text, groupSearchPrefix):
+		criteria, err := refineSearch(ctx, groupID, strings.TrimPrefix(text, groupSearchPrefix))
+		if errors.Is(err, errGeminiQuota) {
+			return replyWithText(replyToken, quotaExceededMessage)
+		}
+		if err != nil {
+			log.Printf("Gemini parsing error: %v", err)
+		}
+		if criteria == nil {
+			return nil
+		}
+		return replyWithCarousel(ctx, replyToken, PetDB.SearchPets(criteria), "為大家找到這些寵物", createShortlistButton, groupSuggestions())
+	}
+	return nil
+}
+
+func replyWithGroupPet(ctx context.Context, replyToken string, pet *Pet) error {
+	return replyWithSinglePet(ctx, replyToken, pet, createShortlistButton, groupSuggestions())
+}
+
+func handleAddToShortlist(ctx context.Context, replyToken, groupID, userID string, petID int) error {
+	if groupID == "" {
+		return replyWithText(replyToken, "群組清單只能在群組或多人聊天中使用。")
+	}
+	pet := PetDB.GetPet(petID)
+	if pet == nil {
+		return replyWithText(replyToken, "這隻寵物已不在認養名單中。")
+	}
+	if err := groupStore.AddToShortlist(ctx, groupID, petID, userID); err != nil {
+		log.Printf("Error adding to shortlist: %v", err)
+		return replyWithText(replyToken, "加入群組清單失敗，請稍後再試。")
+	}
+	log.Printf("User %s shortlisted pet %d in %s", userID, petID, groupID)
+
+	message := fmt.Sprintf("已將「%s」加入群組清單！輸入「%s」和大家一起投票。", pet.Name, shortlistCommand)
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(message).WithQuickReplies(groupSuggestions())).Do()
+	return err
+}
+
+func handleVote(ctx context.Context, replyToken, groupID, userID string, petID int) error {
+	if groupID == "" {
+		return replyWithText(replyToken, "投票只能在群組或多人聊天中使用。")
+	}
+	if userID == "" {
+		return replyWithText(replyToken, "無法辨識您的身分，請先將 LINE 更新到最新版本再投票。")
+	}
+	counted, err := groupStore.Vote(ctx, groupID, petID, userID)
+	if err != nil {
+		log.Printf("Error voting: %v", err)
+		return replyWithText(replyToken, "投票失敗，請稍後再試。")
+	}
+	if !counted {
+		return replyWithText(replyToken, "您已經投過這隻了。")
+	}
+	log.Printf("User %s voted for pet %d in %s", userID, petID, groupID)
+	return handleShowShortlist(ctx, replyToken, groupID)
+}
+
+// handleShowShortlist replies with the group's shortlist, most votes first.
+// Pets that are no longer listed are left out.
+func handleShowShortlist(ctx context.Context, replyToken, groupID string) error {
+	entries, err := groupStore.Shortlist(ctx, groupID)
+	if err != nil {
+		log.Printf("Error getting shortlist: %v", err)
+		return replyWithText(replyToken, "抱歉，讀取群組清單時發生錯誤。")
+	}
+
+	var pets []*Pet
+	votes := make(map[int]int)
+	for _, entry := range entries {
+		if pet := PetDB.GetPet(entry.PetID); pet != nil {
+			pets = append(pets, pet)
+			votes[pet.ID] = len(entry.Voters)
+		}
+	}
+	if len(pets) == 0 {
+		return replyWithText(replyToken, "群組清單是空的，看到喜歡的寵物可以按「加入群組清單」。")
+	}
+	voteButton := func(pet *Pet) *linebot.ButtonComponent {
+		return createVoteButton(pet, votes[pet.ID])
+	}
+	return replyWithCarousel(ctx, replyToken, pets, "群組清單", voteButton, groupSuggestions())
+}
+
+func handleClearShortlist(ctx context.Context, replyToken, groupID string) error {
+	if err := groupStore.ClearShortlist(ctx, groupID); err != nil {
+		log.Printf("Error clearing shortlist: %v", err)
+		return replyWithText(replyToken, "清空群組清單失敗，請稍後再試。")
+	}
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("已清空群組清單。")).Do()
+	return err
+}
+
+func createShortlistButton(pet *Pet) *linebot.ButtonComponent {
+	return &linebot.ButtonComponent{
+		Type:   linebot.FlexComponentTypeButton,
+		Style:  linebot.FlexButtonStyleTypePrimary,
+		Action: linebot.NewPostbackAction("加入群組清單", Postback{Action: postbackShortlist, PetID: pet.ID}.Encode(), "", "加入群組清單", "", ""),
+	}
+}
+
+func createVoteButton(pet *Pet, votes int) *linebot.ButtonComponent {
+	label := fmt.Sprintf("投一票（目前 %d 票）", votes)
+	return &linebot.ButtonComponent{
+		Type:   linebot.FlexComponentTypeButton,
+		Style:  linebot.FlexButtonStyleTypePrimary,
+		Action: linebot.NewPostbackAction(label, Postback{Action: postbackVote, PetID: pet.ID}.Encode(), "", "投一票", "", ""),
+	}
+}
@@ -0,0 +1,276 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// imagePath is where the bot serves shelter photos, as /img/{id} for the
+	// hero variant and /img/{id}/{variant} for the others.
+	imagePath = "/img/"
+	// metricsPath serves image cache counters in the Prometheus text format.
+	metricsPath = "/metrics"
+	// placeholderImageURL is shown for pets without a usable photo.
+	placeholderImageURL = "https://petneed.me/static/img/petNeedme_full_color.png"
+	// maxImageSize is the largest photo the proxy will serve; LINE rejects
+	// hero images over 10 MB.
+	maxImageSize = 10 << 20
+	// imageFetchTimeout bounds how long a shelter site may take to respond.
+	imageFetchTimeout = 10 * time.Second
+	// maxImageRedirects is how many redirects a photo address may take.
+	maxImageRedirects = 5
+)
+
+// PublicURL is the HTTPS base URL the bot is reachable at, from PUBLIC_URL.
+var PublicURL string
+
+// imageResolveTimeout is how long a reply waits for photos to be cached
+// before showing the placeholder, well inside the reply token window.
+var imageResolveTimeout = 2 * time.Second
+
+// imageResolveAfter starts the imageResolveTimeout clock; tests replace it to
+// control the deadline.
+var imageResolveAfter = time.After
+
+// imageClient fetches shelter photos.
+var imageClient = &http.Client{Timeout: imageFetchTimeout, CheckRedirect: checkImageRedirect}
+
+var (
+	errNotImage        = errors.New("not an image")
+	errPrivateRedirect = errors.New("redirect to a non-public address")
+)
+
+// nonPublicPrefixes are ranges netip does not classify but that are not
+// reachable on the internet: "this network" and carrier-grade NAT.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// checkImageRedirect follows redirects only over HTTP(S) to public addresses,
+// so a shelter site cannot point the bot at hosts on its own network.
+func checkImageRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxImageRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxImageRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+	}
+	host := req.URL.Hostname()
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else {
+		resolved, err := net.DefaultResolver.LookupNetIP(req.Context(), "ip", host)
+		if err != nil {
+			return err
+		}
+		addrs = resolved
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return fmt.Errorf("%w: %s", errPrivateRedirect, host)
+		}
+	}
+	return nil
+}
+
+// isPublicAddr reports whether addr is reachable on the internet, rather than
+// loopback, private, link-local or otherwise reserved.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func initializePublicURL() {
+	PublicURL = strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if PublicURL == "" {
+		log.Println("Warning: PUBLIC_URL is not set, pet cards will show a placeholder image.")
+	} else if !strings.HasPrefix(PublicURL, "https://") {
+		log.Printf("Warning: PUBLIC_URL %q is not HTTPS, LINE will not load images from it.", PublicURL)
+	}
+}
+
+// petImageURL returns the HTTPS address of the given variant of the pet's
+// photo, served through the bot's own proxy, or the placeholder if there is
+// none. It never changes pet, so ImageName always holds the shelter's original
+// address.
+func petImageURL(pet *Pet, variant imageVariant) string {
+	if pet.ImageName == "" || PublicURL == "" {
+		return placeholderImageURL
+	}
+	url := PublicURL + imagePath + strconv.Itoa(pet.ID)
+	if variant != heroVariant {
+		url += "/" + variant.Name
+	}
+	return url
+}
+
+// resolveImageURLs returns the image address to show for each pet, keyed by
+// animal ID. Cached photos are used as they are; the others are downloaded and
+// resized concurrently, and any not ready within imageResolveTimeout, or that
+// failed recently, get the placeholder.
+// Downloads that miss the deadline carry on in the background, so the photo is
+// ready the next time the pet is shown.
+func resolveImageURLs(ctx context.Context, pets []*Pet, variant imageVariant) map[int]string {
+	urls := make(map[int]string, len(pets))
+	type result struct {
+		pet *Pet
+		ok  bool
+	}
+	results := make(chan result, len(pets))
+	pending := 0
+
+	for _, pet := range pets {
+		url := petImageURL(pet, variant)
+		if url == placeholderImageURL || photos.Quality(pet) == photoMissing {
+			urls[pet.ID] = placeholderImageURL
+			continue
+		}
+		if images.Has(pet.ImageName, variant) {
+			urls[pet.ID] = url
+			continue
+		}
+		pending++
+		go func(pet *Pet) {
+			_, err := images.Get(ctx, pet.ImageName, variant)
+			if err != nil {
+				log.Printf("Failed to get %s image for pet %d from %s: %v", variant.Name, pet.ID, pet.ImageName, err)
+			}
+			results <- result{pet, err == nil}
+		}(pet)
+	}
+
+	deadline := imageResolveAfter(imageResolveTimeout)
+	for ; pending > 0; pending-- {
+		select {
+		case r := <-results:
+			photos.Record(r.pet, r.ok)
+			if r.ok {
+				urls[r.pet.ID] = petImageURL(r.pet, variant)
+			} else {
+				urls[r.pet.ID] = placeholderImageURL
+			}
+		case <-deadline:
+			for _, pet := range pets {
+				if _, ok := urls[pet.ID]; !ok {
+					urls[pet.ID] = placeholderImageURL
+				}
+			}
+			return urls
+		}
+	}
+	return urls
+}
+
+// imageHandler serves /img/{id}[/{variant}]: the photo of a listed pet,
+// resized to the variant and cached on disk. Only addresses from the
+// catalogue are fetched, so the endpoint cannot be used as an open proxy.
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	idPart, variantName, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, imagePath), "/")
+	variant := heroVariant
+	if variantName != "" {
+		v, ok := imageVariants[strings.TrimSuffix(variantName, ".jpg")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		variant = v
+	}
+	id, err := strconv.Atoi(strings.TrimSuffix(idPart, ".jpg"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	pet := PetDB.GetPet(id)
+	if pet == nil || pet.ImageName == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := images.Get(r.Context(), pet.ImageName, variant)
+	if err != nil {
+		log.Printf("Failed to get %s image for pet %d from %s: %v", variant.Name, id, pet.ImageName, err)
+		http.Error(w, "image unavailable", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
+
+// metricsHandler reports the image cache counters.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := images.Stats()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "petneedme_image_cache_hits_total %d\n", stats.Hits)
+	fmt.Fprintf(w, "petneedme_image_cache_misses_total %d\n", stats.Misses)
+	fmt.Fprintf(w, "petneedme_image_cache_evictions_total %d\n", stats.Evictions)
+	fmt.Fprintf(w, "petneedme_image_cache_files %d\n", stats.Files)
+	fmt.Fprintf(w, "petneedme_image_cache_bytes %d\n", stats.Bytes)
+	fmt.Fprintf(w, "petneedme_image_cache_hit_ratio %g\n", stats.HitRate())
+}
+
+// fetchImage downloads src and returns it with its sniffed content type. It
+// fails if src is not a JPEG or PNG image, the formats LINE displays, or is
+// larger than maxImageSize.
+func fetchImage(ctx context.Context, src string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxImageSize {
+		return nil, "", fmt.Errorf("image is larger than %d bytes", maxImageSize)
+	}
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png":
+		return data, contentType, nil
+	default:
+		return nil, "", fmt.Errorf("%w: %s", errNotImage, contentType)
+	}
+}
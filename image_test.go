@@ -0,0 +1,225 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withShelterPhotos points PetDB at pets whose photos are served by a fake
+// shelter site: pets 1 and 4 have a PNG, pet 2 an HTML error page and pet 3 no
+// photo.
+func withShelterPhotos(t *testing.T) {
+	photo := testPNG(t)
+	shelter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.png", "/4.png":
+			w.Write(photo)
+		default:
+			w.Write([]byte("<html>Not found</html>"))
+		}
+	}))
+	t.Cleanup(shelter.Close)
+
+	cache, err := newImageCache(t.TempDir(), 1<<20, fetchImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedImages := images
+	t.Cleanup(func() { images = savedImages })
+	images = cache
+
+	saved := PetDB
+	t.Cleanup(func() { PetDB = saved })
+	PetDB = new(Pets)
+	PetDB.LoadPets(TaiwanPets{
+		{AnimalID: 1, AnimalKind: "狗", AlbumFile: shelter.URL + "/1.png"},
+		{AnimalID: 2, AnimalKind: "貓", AlbumFile: shelter.URL + "/2.png"},
+		{AnimalID: 3, AnimalKind: "貓"},
+		{AnimalID: 4, AnimalKind: "狗", AlbumFile: shelter.URL + "/4.png"},
+	})
+
+	savedPhotos := photos
+	t.Cleanup(func() { photos = savedPhotos })
+	photos = newPhotoRegistry()
+}
+
+func TestImageHandler(t *testing.T) {
+	withShelterPhotos(t)
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/img/1", http.StatusOK},
+		{"/img/1.jpg", http.StatusOK},
+		{"/img/1/preview", http.StatusOK},
+		{"/img/1/preview.jpg", http.StatusOK},
+		{"/img/1/huge", http.StatusNotFound},
+		{"/img/2", http.StatusBadGateway},
+		{"/img/3", http.StatusNotFound},
+		{"/img/99", http.StatusNotFound},
+		{"/img/abc", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		imageHandler(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && rec.Header().Get("Content-Type") != "image/jpeg" {
+			t.Errorf("%s: got content type %q, want image/jpeg", tt.path, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestPetImageURL(t *testing.T) {
+	saved := PublicURL
+	defer func() { PublicURL = saved }()
+
+	pet := &Pet{ID: 42, ImageName: "http://shelter.example/42.jpg"}
+	PublicURL = ""
+	if got := petImageURL(pet, heroVariant); got != placeholderImageURL {
+		t.Errorf("Expected the placeholder without PUBLIC_URL, got %s", got)
+	}
+
+	PublicURL = "https://bot.example"
+	if got := petImageURL(pet, heroVariant); got != "https://bot.example/img/42" {
+		t.Errorf("Unexpected image URL %s", got)
+	}
+	if got := petImageURL(pet, previewVariant); got != "https://bot.example/img/42/preview" {
+		t.Errorf("Unexpected preview URL %s", got)
+	}
+	if pet.ImageName != "http://shelter.example/42.jpg" {
+		t.Errorf("petImageURL must not change the pet, got %s", pet.ImageName)
+	}
+	if got := petImageURL(&Pet{ID: 43}, heroVariant); got != placeholderImageURL {
+		t.Errorf("Expected the placeholder for a pet without a photo, got %s", got)
+	}
+}
+
+func TestResolveImageURLs(t *testing.T) {
+	withShelterPhotos(t)
+	savedURL, savedAfter := PublicURL, imageResolveAfter
+	defer func() { PublicURL, imageResolveAfter = savedURL, savedAfter }()
+	PublicURL = "https://bot.example"
+	expire := make(chan time.Time)
+	imageResolveAfter = func(time.Duration) <-chan time.Time { return expire }
+
+	// Pet 4's photo arrives only once released.
+	photo := testPNG(t)
+	started, release := make(chan bool, 1), make(chan bool)
+	fetch := func(ctx context.Context, src string) ([]byte, string, error) {
+		switch {
+		case strings.HasSuffix(src, "/1.png"):
+			return photo, "image/png", nil
+		case strings.HasSuffix(src, "/4.png"):
+			started <- true
+			<-release
+			return photo, "image/png", nil
+		}
+		return nil, "", errNotImage
+	}
+	cache, err := newImageCache(t.TempDir(), 1<<20, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images = cache
+
+	// Without a deadline every download finishes.
+	pets := []*Pet{PetDB.GetPet(1), PetDB.GetPet(2), PetDB.GetPet(3), PetDB.GetPet(4)}
+	urls := resolveImageURLs(context.Background(), pets[:3], previewVariant)
+	if urls[1] != "https://bot.example/img/1/preview" || urls[2] != placeholderImageURL || urls[3] != placeholderImageURL {
+		t.Fatalf("Unexpected URLs %v", urls)
+	}
+
+	// Now pet 1 is cached and pet 2 known to be broken, so only pet 4 is
+	// waited for, until the deadline.
+	done := make(chan map[int]string)
+	go func() { done <- resolveImageURLs(context.Background(), pets, previewVariant) }()
+	<-started
+	expire <- time.Now()
+	urls = <-done
+	want := map[int]string{
+		1: "https://bot.example/img/1/preview",
+		2: placeholderImageURL,
+		3: placeholderImageURL,
+		4: placeholderImageURL,
+	}
+	for id, url := range want {
+		if urls[id] != url {
+			t.Errorf("Pet %d: got %s, want %s", id, urls[id], url)
+		}
+	}
+
+	// The slow photo keeps downloading and is ready next time.
+	close(release)
+	if _, err := images.Get(context.Background(), pets[3].ImageName, previewVariant); err != nil {
+		t.Fatal(err)
+	}
+	if url := resolveImageURLs(context.Background(), pets[3:], previewVariant)[4]; url != "https://bot.example/img/4/preview" {
+		t.Errorf("Expected the slow photo once cached, got %s", url)
+	}
+}
+
+func TestFetchImageRefusesPrivateRedirects(t *testing.T) {
+	// The test server itself is on loopback, so any redirect from it to
+	// another loopback address stands in for a shelter pointing inwards.
+	shelter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://127.0.0.1:1/admin.png", http.StatusFound)
+	}))
+	defer shelter.Close()
+
+	if _, _, err := fetchImage(context.Background(), shelter.URL+"/a.png"); !errors.Is(err, errPrivateRedirect) {
+		t.Errorf("Expected the redirect to be refused, got %v", err)
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := map[string]bool{
+		"8.8.8.8":         true,
+		"2001:4860::8888": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	}
+	for addr, public := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, public)
+		}
+	}
+}
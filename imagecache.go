@@ -0,0 +1,389 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // Shelter photos may be PNG
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// imageVariant is a resized version of a shelter photo. Both variants use the
+// 20:13 aspect ratio of the flex hero images.
+type imageVariant struct {
+	Name          string
+	Width, Height int
+}
+
+var (
+	// heroVariant is shown on single pet cards.
+	heroVariant = imageVariant{Name: "hero", Width: 1040, Height: 676}
+	// previewVariant is shown in carousels, where cards are narrower.
+	previewVariant = imageVariant{Name: "preview", Width: 520, Height: 338}
+)
+
+var imageVariants = map[string]imageVariant{
+	heroVariant.Name:    heroVariant,
+	previewVariant.Name: previewVariant,
+}
+
+const (
+	// jpegQuality is used for all resized variants.
+	jpegQuality = 82
+	// maxImagePixels is the largest photo that will be decoded. A small file
+	// can declare huge dimensions, and decoding allocates for all of them.
+	maxImagePixels = 25_000_000
+)
+
+// ImageCacheStats counts cache activity since startup.
+type ImageCacheStats struct {
+	Hits, Misses, Evictions int64
+	Files                   int
+	Bytes                   int64
+}
+
+// HitRate is the share of variant requests served from the cache.
+func (s ImageCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// imageCache downloads each shelter photo once and keeps it, along with its
+// resized variants, in a directory bounded to maxBytes. The least recently
+// used files are evicted first.
+type imageCache struct {
+	dir      string
+	maxBytes int64
+	fetch    func(ctx context.Context, src string) ([]byte, string, error)
+	group    singleflight.Group
+
+	mu      sync.Mutex
+	files   map[string]*cachedFile
+	size    int64
+	stats   ImageCacheStats
+	nowFunc func() time.Time
+}
+
+type cachedFile struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// images is the cache behind the /img endpoint.
+var images *imageCache
+
+// initializeImageCache sets up the image cache in IMAGE_CACHE_DIR (default a
+// directory under the system temp dir), bounded to IMAGE_CACHE_MAX_MB
+// (default 200).
+func initializeImageCache() error {
+	dir := os.Getenv("IMAGE_CACHE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "petneedme-images")
+	}
+	maxMB := int64(200)
+	if v := os.Getenv("IMAGE_CACHE_MAX_MB"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid IMAGE_CACHE_MAX_MB %q: %w", v, err)
+		}
+		maxMB = n
+	}
+	cache, err := newImageCache(dir, maxMB<<20, fetchImage)
+	if err != nil {
+		return err
+	}
+	images = cache
+	return nil
+}
+
+// newImageCache opens the cache in dir, picking up files left by a previous
+// run.
+func newImageCache(dir string, maxBytes int64, fetch func(ctx context.Context, src string) ([]byte, string, error)) (*imageCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating image cache directory: %w", err)
+	}
+	c := &imageCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		fetch:    fetch,
+		files:    make(map[string]*cachedFile),
+		nowFunc:  time.Now,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading image cache directory: %w", err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		c.files[entry.Name()] = &cachedFile{size: info.Size(), lastUsed: info.ModTime()}
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Get returns the variant of the photo at src as a JPEG, downloading and
+// resizing it on first use.
+func (c *imageCache) Get(ctx context.Context, src string, variant imageVariant) ([]byte, error) {
+	key := cacheKey(src) + "-" + variant.Name + ".jpg"
+	if data, ok := c.read(key); ok {
+		c.count(func(s *ImageCacheStats) { s.Hits++ })
+		return data, nil
+	}
+	c.count(func(s *ImageCacheStats) { s.Misses++ })
+
+	// Concurrent requests for the same photo share one download and resize,
+	// which must not be cut short if the first requester goes away.
+	ctx = context.WithoutCancel(ctx)
+	data, err, _ := c.group.Do(key, func() (interface{}, error) {
+		original, err := c.original(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		resized, err := resizeImage(original, variant)
+		if err != nil {
+			return nil, err
+		}
+		c.write(key, resized)
+		return resized, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// Has reports whether the variant of the photo at src is cached, without
+// reading it.
+func (c *imageCache) Has(src string, variant imageVariant) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.files[cacheKey(src)+"-"+variant.Name+".jpg"]
+	return ok
+}
+
+// original returns the downloaded photo, fetching it if it is not cached.
+func (c *imageCache) original(ctx context.Context, src string) ([]byte, error) {
+	key := cacheKey(src) + ".orig"
+	if data, ok := c.read(key); ok {
+		return data, nil
+	}
+	data, err, _ := c.group.Do(key, func() (interface{}, error) {
+		data, _, err := c.fetch(ctx, src)
+		if err != nil {
+			return nil, err
+		}
+		c.write(key, data)
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// Stats returns the cache counters.
+func (c *imageCache) Stats() ImageCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Files = len(c.files)
+	stats.Bytes = c.size
+	return stats
+}
+
+func (c *imageCache) count(update func(*ImageCacheStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(&c.stats)
+}
+
+func (c *imageCache) read(key string) ([]byte, bool) {
+	c.mu.Lock()
+	f, ok := c.files[key]
+	if ok {
+		f.lastUsed = c.nowFunc()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		// Removed behind our back; forget it and fetch again.
+		c.mu.Lock()
+		if f, ok := c.files[key]; ok {
+			c.size -= f.size
+			delete(c.files, key)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// write stores data under key. Failing to cache is logged but not fatal; the
+// caller still has the data.
+func (c *imageCache) write(key string, data []byte) {
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		log.Printf("Failed to cache image %s: %v", key, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Failed to cache image %s: %v", key, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.files[key]; ok {
+		c.size -= old.size
+	}
+	c.files[key] = &cachedFile{size: int64(len(data)), lastUsed: c.nowFunc()}
+	c.size += int64(len(data))
+	c.evictLocked()
+}
+
+// evictLocked removes the least recently used files until the cache fits in
+// maxBytes. c.mu must be held.
+func (c *imageCache) evictLocked() {
+	for c.size > c.maxBytes && len(c.files) > 0 {
+		var oldest string
+		for key, f := range c.files {
+			if oldest == "" || f.lastUsed.Before(c.files[oldest].lastUsed) {
+				oldest = key
+			}
+		}
+		if err := os.Remove(filepath.Join(c.dir, oldest)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to evict cached image %s: %v", oldest, err)
+		}
+		c.size -= c.files[oldest].size
+		delete(c.files, oldest)
+		c.stats.Evictions++
+	}
+}
+
+func cacheKey(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// resizeImage crops the photo to the variant's aspect ratio around its centre
+// and scales it to the variant's size.
+func resizeImage(data []byte, variant imageVariant) ([]byte, error) {
+	conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+	if conf.Width == 0 || conf.Height == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if int64(conf.Width)*int64(conf.Height) > maxImagePixels {
+		return nil, fmt.Errorf("image is %dx%d, larger than %d pixels", conf.Width, conf.Height, maxImagePixels)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	// Crop to the target aspect ratio, keeping the centre.
+	b := src.Bounds()
+	crop := b
+	if b.Dx()*variant.Height > b.Dy()*variant.Width {
+		// Keep at least a pixel of very tall or wide photos.
+		w := max(b.Dy()*variant.Width/variant.Height, 1)
+		crop.Min.X = b.Min.X + (b.Dx()-w)/2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		h := max(b.Dx()*variant.Height/variant.Width, 1)
+		crop.Min.Y = b.Min.Y + (b.Dy()-h)/2
+		crop.Max.Y = crop.Min.Y + h
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, crop.Min, draw.Src)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleRGBA(rgba, variant.Width, variant.Height), &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleRGBA resizes src to w x h by averaging the source pixels that fall in
+// each destination pixel.
+func scaleRGBA(src *image.RGBA, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					b += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(b / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
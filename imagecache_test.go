@@ -0,0 +1,183 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFetch serves a 300x200 PNG for every address and counts downloads.
+func countingFetch(t *testing.T) (func(context.Context, string) ([]byte, string, error), *int32) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	return func(ctx context.Context, src string) ([]byte, string, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return buf.Bytes(), "image/png", nil
+	}, &calls
+}
+
+func TestImageCacheVariants(t *testing.T) {
+	fetch, calls := countingFetch(t)
+	cache, err := newImageCache(t.TempDir(), 10<<20, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, variant := range []imageVariant{heroVariant, previewVariant} {
+		data, err := cache.Get(context.Background(), "http://shelter.example/1.png", variant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s is not a JPEG: %v", variant.Name, err)
+		}
+		if b := img.Bounds(); b.Dx() != variant.Width || b.Dy() != variant.Height {
+			t.Errorf("%s: got %dx%d, want %dx%d", variant.Name, b.Dx(), b.Dy(), variant.Width, variant.Height)
+		}
+	}
+	if _, err := cache.Get(context.Background(), "http://shelter.example/1.png", heroVariant); err != nil {
+		t.Fatal(err)
+	}
+
+	if *calls != 1 {
+		t.Errorf("Expected the photo to be downloaded once, got %d", *calls)
+	}
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("Expected 1 hit and 2 misses, got %+v", stats)
+	}
+	if stats.Files != 3 {
+		t.Errorf("Expected the original and two variants on disk, got %d files", stats.Files)
+	}
+}
+
+func TestImageCacheConcurrent(t *testing.T) {
+	fetch, calls := countingFetch(t)
+	cache, err := newImageCache(t.TempDir(), 10<<20, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Get(context.Background(), "http://shelter.example/1.png", heroVariant); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if *calls != 1 {
+		t.Errorf("Expected concurrent requests to share one download, got %d", *calls)
+	}
+}
+
+func TestImageCacheEviction(t *testing.T) {
+	fetch, calls := countingFetch(t)
+	dir := t.TempDir()
+	cache, err := newImageCache(dir, 10<<20, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cache.nowFunc = func() time.Time { now = now.Add(time.Second); return now }
+
+	ctx := context.Background()
+	if _, err := cache.Get(ctx, "http://shelter.example/1.png", previewVariant); err != nil {
+		t.Fatal(err)
+	}
+	// Shrink the cache so it holds about one photo and its variant.
+	cache.maxBytes = cache.Stats().Bytes + 1
+	if _, err := cache.Get(ctx, "http://shelter.example/2.png", previewVariant); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := cache.Stats()
+	if stats.Evictions == 0 {
+		t.Error("Expected files to be evicted")
+	}
+	if stats.Bytes > cache.maxBytes {
+		t.Errorf("Cache holds %d bytes, more than the limit of %d", stats.Bytes, cache.maxBytes)
+	}
+	if _, err := cache.Get(ctx, "http://shelter.example/1.png", previewVariant); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 3 {
+		t.Errorf("Expected the evicted photo to be downloaded again, got %d downloads", *calls)
+	}
+
+	// A restarted cache picks up what is on disk.
+	reopened, err := newImageCache(dir, cache.maxBytes, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reopened.Stats().Bytes, cache.Stats().Bytes; got != want {
+		t.Errorf("Reopened cache has %d bytes, want %d", got, want)
+	}
+}
+
+func TestResizeImageRejectsHugeDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	// Claim 40000x40000 in the IHDR chunk, which follows the 8-byte signature
+	// and the chunk length and type, and fix its checksum.
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 40000)
+	binary.BigEndian.PutUint32(data[20:], 40000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	if _, err := resizeImage(data, previewVariant); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Expected a 1.6 gigapixel image to be rejected by size, got %v", err)
+	}
+}
+
+func TestResizeImageThinPhotos(t *testing.T) {
+	// A 1 px wide photo would crop to no rows at all.
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 1, 50), image.Rect(0, 0, 50, 1), image.Rect(0, 0, 1, 1)} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(r)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := resizeImage(buf.Bytes(), previewVariant)
+		if err != nil {
+			t.Fatalf("%v: %v", r.Size(), err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.Bounds().Size(); got != image.Pt(previewVariant.Width, previewVariant.Height) {
+			t.Errorf("%v: got a %v image", r.Size(), got)
+		}
+	}
+}
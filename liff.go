@@ -0,0 +1,352 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// liffPath serves the LIFF app; register it as the LIFF endpoint URL.
+	liffPath = "/liff/"
+	// liffAPIPath is the JSON API behind the LIFF app.
+	liffAPIPath = "/liff/api/"
+	// lineVerifyURL returns the channel an access token was issued to.
+	lineVerifyURL = "https://api.line.me/oauth2/v2.1/verify"
+	// lineProfileURL returns the profile of the user an access token belongs to.
+	lineProfileURL = "https://api.line.me/v2/profile"
+	// liffTokenTTL is how long a verified access token is trusted before the
+	// profile API is asked again.
+	liffTokenTTL = 10 * time.Minute
+)
+
+// LIFFID is the LIFF app ID from LIFF_ID. Without it the page still lists
+// pets, but cannot identify the user to show or change favourites.
+var LIFFID string
+
+func initializeLIFF() {
+	LIFFID = os.Getenv("LIFF_ID")
+	if LIFFID == "" {
+		log.Println("Warning: LIFF_ID is not set, the web app will not support favourites.")
+	}
+	liffVerifier = newLineProfileVerifier(liffChannelID(LIFFID))
+}
+
+// liffChannelID returns the LINE Login channel a LIFF app belongs to. LIFF IDs
+// are the channel ID followed by a dash and a random suffix.
+func liffChannelID(liffID string) string {
+	channelID, _, _ := strings.Cut(liffID, "-")
+	return channelID
+}
+
+var liffPage = template.Must(template.ParseFS(webPages, "web/liff.html"))
+
+// liffPageHandler serves the LIFF app.
+func liffPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != liffPath {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := liffPage.Execute(w, LIFFID); err != nil {
+		log.Printf("Failed to render LIFF page: %v", err)
+	}
+}
+
+// userVerifier returns the LINE user an access token belongs to.
+type userVerifier interface {
+	UserID(ctx context.Context, accessToken string) (string, error)
+}
+
+var errInvalidToken = errors.New("invalid access token")
+
+// lineProfileVerifier checks that a LIFF access token was issued to this
+// app's channel, then asks the LINE profile API who it belongs to, remembering
+// the answer for liffTokenTTL. Tokens from other LINE Login channels are
+// rejected, so their apps cannot act for their users here.
+type lineProfileVerifier struct {
+	client     *http.Client
+	verifyURL  string
+	profileURL string
+	channelID  string
+
+	mu     sync.Mutex
+	tokens map[string]verifiedToken
+}
+
+type verifiedToken struct {
+	userID  string
+	expires time.Time
+}
+
+func newLineProfileVerifier(channelID string) *lineProfileVerifier {
+	return &lineProfileVerifier{
+		client:     &http.Client{Timeout: 5 * time.Second},
+		verifyURL:  lineVerifyURL,
+		profileURL: lineProfileURL,
+		channelID:  channelID,
+		tokens:     make(map[string]verifiedToken),
+	}
+}
+
+// liffVerifier accepts no tokens until initializeLIFF knows the channel.
+var liffVerifier userVerifier = newLineProfileVerifier("")
+
+func (v *lineProfileVerifier) UserID(ctx context.Context, accessToken string) (string, error) {
+	v.mu.Lock()
+	t, ok := v.tokens[accessToken]
+	v.mu.Unlock()
+	if ok && time.Now().Before(t.expires) {
+		return t.userID, nil
+	}
+
+	ttl, err := v.verify(ctx, accessToken)
+	if err != nil {
+		return "", err
+	}
+	userID, err := v.profile(ctx, accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for token, t := range v.tokens {
+		if now.After(t.expires) {
+			delete(v.tokens, token)
+		}
+	}
+	v.tokens[accessToken] = verifiedToken{userID: userID, expires: now.Add(min(ttl, liffTokenTTL))}
+	return userID, nil
+}
+
+// verify checks that the token belongs to this app's channel and has not
+// expired, and returns how long it remains valid.
+func (v *lineProfileVerifier) verify(ctx context.Context, accessToken string) (time.Duration, error) {
+	if v.channelID == "" {
+		return 0, errInvalidToken
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.verifyURL+"?access_token="+url.QueryEscape(accessToken), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return 0, errInvalidToken
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("verify API returned %s", resp.Status)
+	}
+	var token struct {
+		ClientID  string `json:"client_id"`
+		ExpiresIn int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return 0, err
+	}
+	if token.ClientID != v.channelID || token.ExpiresIn <= 0 {
+		return 0, errInvalidToken
+	}
+	return time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// profile returns the ID of the user the token belongs to.
+func (v *lineProfileVerifier) profile(ctx context.Context, accessToken string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.profileURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusBadRequest {
+		return "", errInvalidToken
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("profile API returned %s", resp.Status)
+	}
+	var profile struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return "", err
+	}
+	if profile.UserID == "" {
+		return "", errInvalidToken
+	}
+	return profile.UserID, nil
+}
+
+// liffUser returns the user whose LIFF access token is in the Authorization
+// header, or "" if there is none. An invalid token is an error.
+func liffUser(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", nil
+	}
+	return liffVerifier.UserID(r.Context(), token)
+}
+
+// liffPetsHandler serves GET /liff/api/pets: one page of pets matching the
+// filters, see parsePetQuery. Signed-in users see which are favourites.
+func liffPetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q, err := parsePetQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	userID, err := liffUser(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	pets, total := PetDB.Query(q)
+	page := newPetPage(pets, total, q)
+	if userID != "" {
+		favs, err := favoriteIDs(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error getting favorites: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to read favourites")
+			return
+		}
+		for i := range page.Pets {
+			favorite := favs[page.Pets[i].ID]
+			page.Pets[i].Favorite = &favorite
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// liffSheltersHandler serves GET /liff/api/shelters for the shelter filter.
+func liffSheltersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, PetDB.Shelters())
+}
+
+// liffFavoritesHandler serves the signed-in user's favourites:
+//
+//	GET    /liff/api/favorites          the listed favourites
+//	PUT    /liff/api/favorites/{id}     add a pet
+//	DELETE /liff/api/favorites/{id}     remove a pet
+func liffFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := liffUser(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	if userID == "" {
+		writeJSONError(w, http.StatusUnauthorized, "sign in with LINE to use favourites")
+		return
+	}
+	ctx := r.Context()
+
+	idPart := strings.Trim(strings.TrimPrefix(r.URL.Path, liffAPIPath+"favorites"), "/")
+	if idPart == "" {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		favs, err := favoriteStore.Favorites(ctx, userID)
+		if err != nil {
+			log.Printf("Error getting favorites: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to read favourites")
+			return
+		}
+		pets := make([]petJSON, 0, len(favs))
+		for _, fav := range favs {
+			if pet := PetDB.GetPet(fav.PetID); pet != nil {
+				p := newPetJSON(pet)
+				favorite := true
+				p.Favorite = &favorite
+				pets = append(pets, p)
+			}
+		}
+		writeJSON(w, http.StatusOK, petPage{Pets: pets, Total: len(pets)})
+		return
+	}
+
+	petID, err := strconv.Atoi(idPart)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "no such pet")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if PetDB.GetPet(petID) == nil {
+			writeJSONError(w, http.StatusNotFound, "no such pet")
+			return
+		}
+		err = favoriteStore.AddFavorite(ctx, userID, petID)
+	case http.MethodDelete:
+		err = favoriteStore.RemoveFavorite(ctx, userID, petID)
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating favorite: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to update favourites")
+		return
+	}
+	log.Printf("User %s %s favorite %d from the web app", userID, strings.ToLower(r.Method), petID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func favoriteIDs(ctx context.Context, userID string) (map[int]bool, error) {
+	favs, err := favoriteStore.Favorites(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[int]bool, len(favs))
+	for _, fav := range favs {
+		ids[fav.PetID] = true
+	}
+	return ids, nil
+}
+
+func writeAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidToken) {
+		writeJSONError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+	log.Printf("Error verifying access token: %v", err)
+	writeJSONError(w, http.StatusBadGateway, "could not verify access token")
+}
@@ -0,0 +1,187 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVerifier accepts the token "good" for user U1.
+type fakeVerifier struct{}
+
+func (fakeVerifier) UserID(ctx context.Context, token string) (string, error) {
+	if token == "good" {
+		return "U1", nil
+	}
+	return "", errInvalidToken
+}
+
+func withLIFF(t *testing.T) {
+	withQueryPets(t)
+	savedVerifier, savedStore := liffVerifier, favoriteStore
+	t.Cleanup(func() { liffVerifier, favoriteStore = savedVerifier, savedStore })
+	liffVerifier = fakeVerifier{}
+	favoriteStore = newMemoryStore()
+}
+
+func liffRequest(t *testing.T, handler http.HandlerFunc, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestLIFFPets(t *testing.T) {
+	withLIFF(t)
+	if err := favoriteStore.AddFavorite(context.Background(), "U1", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := liffRequest(t, liffPetsHandler, http.MethodGet, "/liff/api/pets?kind=狗&limit=2", "good")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	var page petPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || len(page.Pets) != 2 || page.Next == nil || *page.Next != 2 {
+		t.Fatalf("Unexpected page %+v", page)
+	}
+	if p := page.Pets[1]; p.ID != 2 || p.Sex != "母" || p.Favorite == nil || !*p.Favorite {
+		t.Errorf("Expected pet 2 to be a female favourite, got %+v", p)
+	}
+
+	// Without a token the listing works but favourites are left out.
+	rec = liffRequest(t, liffPetsHandler, http.MethodGet, "/liff/api/pets?kind=狗&offset=2", "")
+	var last petPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &last); err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Pets) != 1 || last.Next != nil || last.Pets[0].Favorite != nil {
+		t.Errorf("Unexpected anonymous last page %+v", last)
+	}
+
+	if rec := liffRequest(t, liffPetsHandler, http.MethodGet, "/liff/api/pets", "bad"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an invalid token to be rejected, got %d", rec.Code)
+	}
+	if rec := liffRequest(t, liffPetsHandler, http.MethodGet, "/liff/api/pets?offset=x", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a bad offset to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestLIFFFavorites(t *testing.T) {
+	withLIFF(t)
+
+	for _, tt := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodPut, "/liff/api/favorites/3", "", http.StatusUnauthorized},
+		{http.MethodPut, "/liff/api/favorites/3", "good", http.StatusNoContent},
+		{http.MethodPut, "/liff/api/favorites/1", "good", http.StatusNoContent},
+		{http.MethodPut, "/liff/api/favorites/99", "good", http.StatusNotFound},
+		{http.MethodDelete, "/liff/api/favorites/1", "good", http.StatusNoContent},
+		{http.MethodPost, "/liff/api/favorites/1", "good", http.StatusMethodNotAllowed},
+	} {
+		if rec := liffRequest(t, liffFavoritesHandler, tt.method, tt.path, tt.token); rec.Code != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+		}
+	}
+
+	rec := liffRequest(t, liffFavoritesHandler, http.MethodGet, "/liff/api/favorites", "good")
+	var page petPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Pets) != 1 || page.Pets[0].ID != 3 {
+		t.Errorf("Expected only pet 3 in favourites, got %+v", page.Pets)
+	}
+}
+
+func TestLineProfileVerifier(t *testing.T) {
+	verified, profiled := 0, 0
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/verify":
+			verified++
+			switch r.URL.Query().Get("access_token") {
+			case "good":
+				w.Write([]byte(`{"scope": "profile", "client_id": "1234567890", "expires_in": 2591659}`))
+			case "other":
+				// Issued to another LINE Login channel.
+				w.Write([]byte(`{"scope": "profile", "client_id": "9999999999", "expires_in": 2591659}`))
+			case "expired":
+				w.Write([]byte(`{"scope": "profile", "client_id": "1234567890", "expires_in": 0}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		case "/profile":
+			profiled++
+			w.Write([]byte(`{"userId": "U1", "displayName": "小明"}`))
+		}
+	}))
+	defer api.Close()
+
+	v := newLineProfileVerifier(liffChannelID("1234567890-AbcdEfgh"))
+	v.client, v.verifyURL, v.profileURL = api.Client(), api.URL+"/verify", api.URL+"/profile"
+	for i := 0; i < 2; i++ {
+		if userID, err := v.UserID(context.Background(), "good"); err != nil || userID != "U1" {
+			t.Fatalf("got %q, %v", userID, err)
+		}
+	}
+	if verified != 1 || profiled != 1 {
+		t.Errorf("Expected the verified token to be remembered, got %d verify and %d profile calls", verified, profiled)
+	}
+	for _, token := range []string{"other", "expired", "bad"} {
+		if _, err := v.UserID(context.Background(), token); err != errInvalidToken {
+			t.Errorf("Expected %q to be rejected, got %v", token, err)
+		}
+	}
+	if profiled != 1 {
+		t.Errorf("Expected rejected tokens never to reach the profile API, got %d calls", profiled)
+	}
+
+	v.tokens["good"] = verifiedToken{userID: "U1", expires: time.Now().Add(-time.Second)}
+	v.UserID(context.Background(), "good")
+	if verified != 5 {
+		t.Errorf("Expected an expired token to be checked again, got %d calls", verified)
+	}
+
+	if _, err := newLineProfileVerifier("").UserID(context.Background(), "good"); err != errInvalidToken {
+		t.Errorf("Expected no tokens to be accepted without a channel, got %v", err)
+	}
+}
+
+func TestLIFFPage(t *testing.T) {
+	saved := LIFFID
+	defer func() { LIFFID = saved }()
+	LIFFID = "1234-abcd"
+
+	rec := liffRequest(t, liffPageHandler, http.MethodGet, "/liff/", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `const liffId = "1234-abcd";`) {
+		t.Errorf("Expected the page with the LIFF ID, got %d", rec.Code)
+	}
+	if rec := liffRequest(t, liffPageHandler, http.MethodGet, "/liff/other", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected other paths to be 404, got %d", rec.Code)
+	}
+}
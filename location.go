@@ -0,0 +1,29 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// cityPattern matches a Taiwanese city or county, e.g. 臺北市 or 花蓮縣.
+var cityPattern = regexp.MustCompile(`\p{Han}{2}[市縣]`)
+
+// cityFromAddress returns the city or county of an address shared by LINE,
+// e.g. "100台灣台北市中正區…" gives "臺北市", or "" if there is none.
+func cityFromAddress(address string) string {
+	address = strings.ReplaceAll(address, "台", "臺")
+	address = strings.Replace(address, "臺灣", "", 1)
+	return cityPattern.FindString(address)
+}
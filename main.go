@@ -14,6 +14,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -23,37 +24,114 @@ import (
 	"strconv"
 	"strings"
 
-	firebase "firebase.google.com/go/v4"
-	"firebase.google.com/go/v4/db"
 	"github.com/line/line-bot-sdk-go/v7/linebot"
 )
 
+const (
+	// maxShareProfile is the most of a profile quoted in share text; Gemini is
+	// asked for short profiles but does not always comply.
+	maxShareProfile = 60
+	// maxURILength is the longest URI LINE accepts in an action.
+	maxURILength = 1000
+)
+
+// quotaExceededMessage is the reply when a user has used up the Gemini quota.
+const quotaExceededMessage = "您的 AI 查詢次數已達上限，請稍後再試。"
+
+// Commands sent by the rich menu, see richmenu/richmenu.json.
+const (
+	helpCommand     = "說明"
+	settingsCommand = "設定"
+	nearbyCommand   = "附近"
+)
+
+// menuCommands, sent by the rich menu and quick replies, are handled directly
+// without trying the AI search first.
+var menuCommands = map[string]bool{
+	"狗": true, "貓": true, "dog": true, "cat": true, "收藏": true, "清空收藏": true, "關閉通知": true, "開啟通知": true,
+	helpCommand: true, settingsCommand: true, nearbyCommand: true,
+}
+
+const welcomeMessage = "歡迎加入 PetNeedMe！這裡有全台公立收容所等待認養的貓狗，一起幫牠們找個家吧。"
+
+const joinMessage = `大家好，我是 PetNeedMe！
+・輸入「狗」或「貓」，我會分享一隻等待認養的寵物
+・輸入「找台北的小型母狗」這樣的句子來搜尋
+・看到喜歡的按「加入群組清單」，大家可以一起投票
+・輸入「清單」看看得票最高的寵物`
+
+const helpMessage = `可以這樣跟我說：
+・「我想找台北的小型母狗」：用說的找寵物，之後還可以說「換成貓」、「不限性別」繼續調整
+・傳一張照片：找長得相似的寵物
+・「狗」、「貓」：隨機看一隻
+・「附近」：找您附近收容所的動物
+・「收藏」：查看收藏的寵物
+・「訂閱」：有新的寵物符合目前的搜尋時通知您
+・「我的訂閱」、「取消訂閱」：管理訂閱
+・「重新搜尋」：清除搜尋條件
+看到寵物後，也可以直接問「牠親人嗎？」等問題。`
+
 // Global variables for services
 var (
-	ImgSrv   string
-	bot      *linebot.Client
-	dbClient *db.Client
-	PetDB    *Pets
+	bot               *linebot.Client
+	favoriteStore     FavoritesStore
+	settingsStore     SettingsStore
+	subscriptionStore SubscriptionStore
+	groupStore        GroupStore
+	PetDB             *Pets
 )
 
 // main is the entry point of the application.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "richmenu" {
+		if err := runRichMenu(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to provision rich menu: %v", err)
+		}
+		return
+	}
+
 	var err error
 	ctx := context.Background()
 
 	// Initialize services
-	if err = initializeFirebase(ctx); err != nil {
-		log.Fatalf("Failed to initialize Firebase: %v", err)
+	if err = initializeStore(ctx); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	initializePublicURL()
+	initializeLIFF()
+	if err = initializeFlexTemplates(); err != nil {
+		log.Fatalf("Failed to load flex templates: %v", err)
+	}
+	if err = initializeKnowledge(); err != nil {
+		log.Fatalf("Failed to load adoption knowledge: %v", err)
+	}
+	if err = initializeImageCache(); err != nil {
+		log.Fatalf("Failed to initialize image cache: %v", err)
 	}
-	initializeImgSrv()
 	if err = initializeLineBot(); err != nil {
 		log.Fatalf("Failed to initialize LINE Bot: %v", err)
 	}
 
 	PetDB = NewPets()
+	initializePhotoChecks()
+	go validatePhotos(ctx, photos, PetDB.All())
+	go watchCatalogue(ctx, loadWatcherConfig(), &linePusher{client: bot})
 
 	// Setup HTTP server
 	http.HandleFunc("/callback", callbackHandler)
+	http.HandleFunc(imagePath, imageHandler)
+	http.HandleFunc(metricsPath, metricsHandler)
+	http.HandleFunc(petPagePath, petPageHandler)
+	http.HandleFunc(apiPath+"pets", apiEndpoint(apiPets))
+	http.HandleFunc(apiPath+"pets/", apiEndpoint(apiPet))
+	http.HandleFunc(apiPath+"shelters", apiEndpoint(apiShelters))
+	http.HandleFunc(apiPath+"stats", apiEndpoint(apiStats))
+	http.HandleFunc(apiPath+"openapi.json", apiEndpoint(apiSpec))
+	http.HandleFunc(liffPath, liffPageHandler)
+	http.HandleFunc(liffAPIPath+"pets", liffPetsHandler)
+	http.HandleFunc(liffAPIPath+"shelters", liffSheltersHandler)
+	http.HandleFunc(liffAPIPath+"favorites", liffFavoritesHandler)
+	http.HandleFunc(liffAPIPath+"favorites/", liffFavoritesHandler)
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -68,20 +146,15 @@ func main() {
 
 // --- Initializers ---
 
-func initializeFirebase(ctx context.Context) error {
-	firebaseDBURL := os.Getenv("FIREBASE_DB")
-	if firebaseDBURL == "" {
-		return fmt.Errorf("FIREBASE_DB environment variable must be set")
-	}
-	conf := &firebase.Config{DatabaseURL: firebaseDBURL}
-	app, err := firebase.NewApp(ctx, conf)
+func initializeStore(ctx context.Context) error {
+	store, err := newStore(ctx)
 	if err != nil {
-		return fmt.Errorf("error initializing app: %w", err)
-	}
-	dbClient, err = app.Database(ctx)
-	if err != nil {
-		return fmt.Errorf("error getting Database client: %w", err)
+		return err
 	}
+	favoriteStore = store
+	settingsStore = store
+	subscriptionStore = store
+	groupStore = store
 	return nil
 }
 
@@ -95,10 +168,6 @@ func initializeLineBot() error {
 	return nil
 }
 
-func initializeImgSrv() {
-	ImgSrv = os.Getenv("IMG_SRV")
-}
-
 // --- HTTP Handlers ---
 
 func callbackHandler(w http.ResponseWriter, r *http.Request) {
@@ -113,7 +182,7 @@ func callbackHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, event := range events {
-		ctx := r.Context()
+		ctx := contextWithUser(r.Context(), event.Source.UserID)
 		if err := dispatchEvent(ctx, event); err != nil {
 			log.Printf("Error dispatching event: %v", err)
 		}
@@ -128,8 +197,14 @@ func dispatchEvent(ctx context.Context, event *linebot.Event) error {
 		return handleMessageEvent(ctx, event)
 	case linebot.EventTypePostback:
 		return handlePostbackEvent(ctx, event)
-	case linebot.EventTypeUnsend:
-		return handleUnsendEvent(event)
+	case linebot.EventTypeFollow:
+		return handleFollowEvent(ctx, event)
+	case linebot.EventTypeUnfollow:
+		return handleUnfollowEvent(ctx, event)
+	case linebot.EventTypeJoin:
+		return handleJoinEvent(event)
+	case linebot.EventTypeLeave:
+		return handleLeaveEvent(ctx, event)
 	default:
 		log.Printf("Unhandled event type: %s", event.Type)
 		return nil
@@ -139,65 +214,203 @@ func dispatchEvent(ctx context.Context, event *linebot.Event) error {
 // --- Event Handlers ---
 
 func handleMessageEvent(ctx context.Context, event *linebot.Event) error {
-	msg, ok := event.Message.(*linebot.TextMessage)
-	if !ok {
-		return nil // Not a text message
+	if msg, ok := event.Message.(*linebot.TextMessage); ok {
+		return handleTextMessage(ctx, event, msg)
+	}
+	// Groups share photos and places among themselves; the bot stays quiet
+	// and only answers its text commands there.
+	if chatID(event.Source) != "" {
+		return nil
 	}
+	switch msg := event.Message.(type) {
+	case *linebot.ImageMessage:
+		return handleImageMessage(ctx, event, msg)
+	case *linebot.LocationMessage:
+		return handleLocationMessage(ctx, event, msg)
+	default:
+		return nil
+	}
+}
 
+func handleTextMessage(ctx context.Context, event *linebot.Event, msg *linebot.TextMessage) error {
 	inText := strings.ToLower(strings.TrimSpace(msg.Text))
 	log.Printf("Received message from %s: %s", event.Source.UserID, inText)
 
-	// 1. Try Gemini AI Search
-	criteria, err := ParseSearchCriteriaFromQuery(inText)
+	if groupID := chatID(event.Source); groupID != "" {
+		return handleGroupText(ctx, event.ReplyToken, groupID, inText)
+	}
+
+	if inText == resetCommand {
+		conversations.Reset(event.Source.UserID)
+		_, err := bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage("已清除先前的搜尋條件，請告訴我您想找什麼樣的寵物。")).Do()
+		return err
+	}
+
+	// Rich menu commands are exact phrases; skip the AI search for them
+	if menuCommands[inText] {
+		handleCommand(ctx, event.ReplyToken, event.Source.UserID, inText)
+		return nil
+	}
+
+	// Subscription commands carry free text, so match them before the AI search
+	if handled := handleSubscriptionCommand(ctx, event.ReplyToken, event.Source.UserID, inText); handled {
+		return nil
+	}
+
+	// 1. Answer adoption questions about the current pet
+	if isQuestion(inText) {
+		return handleQuestion(ctx, event.ReplyToken, event.Source.UserID, inText)
+	}
+
+	// 2. Try Gemini AI Search, refining the user's previous criteria
+	criteria, err := refineSearch(ctx, event.Source.UserID, inText)
+	if errors.Is(err, errGeminiQuota) {
+		return replyWithText(event.ReplyToken, quotaExceededMessage)
+	}
 	if err != nil {
 		log.Printf("Gemini parsing error: %v", err)
 	}
 	if criteria != nil {
 		log.Printf("Gemini parsed criteria: %+v", criteria)
 		pets := PetDB.SearchPets(criteria)
-		return replyWithPetCarousel(event.ReplyToken, pets, "為您找到這些寵物")
+		return replyWithSearchResults(ctx, event.ReplyToken, pets, "為您找到這些寵物", criteria)
 	}
 
-	// 2. Handle Text Commands
+	// 3. Handle Text Commands
 	if handled := handleCommand(ctx, event.ReplyToken, event.Source.UserID, inText); handled {
 		return nil
 	}
 
-	// 3. Default: Get a random pet
+	// 4. Default: Get a random pet
 	pet := PetDB.GetNextPet()
-	return replyWithSinglePet(event.ReplyToken, pet)
+	return showPet(ctx, event.ReplyToken, event.Source.UserID, pet)
+}
+
+func handleImageMessage(ctx context.Context, event *linebot.Event, msg *linebot.ImageMessage) error {
+	if petVision == nil {
+		return replyWithText(event.ReplyToken, "抱歉，目前無法辨識照片，請用文字告訴我您想找什麼樣的寵物。")
+	}
+
+	content, err := bot.GetMessageContent(msg.ID).WithContext(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get image content: %w", err)
+	}
+	defer content.Content.Close()
+	image, err := io.ReadAll(io.LimitReader(content.Content, maxPhotoSize))
+	if err != nil {
+		return fmt.Errorf("failed to read image content: %w", err)
+	}
+
+	criteria, pets, err := searchByPhoto(ctx, petVision, PetDB, image, content.ContentType)
+	if errors.Is(err, errGeminiQuota) {
+		return replyWithText(event.ReplyToken, quotaExceededMessage)
+	}
+	if err != nil {
+		log.Printf("Gemini vision error: %v", err)
+		return replyWithText(event.ReplyToken, "抱歉，辨識照片時發生錯誤，請稍後再試。")
+	}
+	if criteria == nil {
+		return replyWithText(event.ReplyToken, "照片中好像沒有貓或狗，換一張試試看吧！")
+	}
+	log.Printf("Gemini vision criteria: %+v", criteria)
+
+	// Remember the criteria so the user can refine them by text.
+	conversations.SetCriteria(event.Source.UserID, criteria)
+	return replyWithSearchResults(ctx, event.ReplyToken, pets, "和照片相似的寵物", criteria)
 }
 
 func handlePostbackEvent(ctx context.Context, event *linebot.Event) error {
-	data := event.Postback.Data
-	params, err := url.ParseQuery(data)
+	postback, err := decodePostback(event.Postback.Data)
 	if err != nil {
-		return fmt.Errorf("failed to parse postback data: %w", err)
+		return err
 	}
 
-	action := params.Get("action")
-	if action == "favorite" {
-		petIDStr := params.Get("petID")
-		return handleAddFavorite(ctx, event.ReplyToken, event.Source.UserID, petIDStr)
+	switch postback.Action {
+	case postbackFavorite:
+		return handleAddFavorite(ctx, event.ReplyToken, event.Source.UserID, postback.PetID)
+	case postbackUnfavorite:
+		return handleRemoveFavorite(ctx, event.ReplyToken, event.Source.UserID, postback.PetID)
+	case postbackMoreLikeThis:
+		return handleMoreLikeThis(ctx, event.ReplyToken, event.Source.UserID, postback.PetID)
+	case postbackShelterInfo:
+		return handleShelterInfo(ctx, event.ReplyToken, event.Source.UserID, postback.PetID)
+	case postbackDetails:
+		return handlePetDetails(ctx, event.ReplyToken, event.Source, postback.PetID)
+	case postbackShortlist:
+		return handleAddToShortlist(ctx, event.ReplyToken, chatID(event.Source), event.Source.UserID, postback.PetID)
+	case postbackVote:
+		return handleVote(ctx, event.ReplyToken, chatID(event.Source), event.Source.UserID, postback.PetID)
+	case postbackClearFavorites:
+		return handleClearFavorites(ctx, event.ReplyToken, event.Source.UserID)
+	case postbackCancel:
+		return replyWithText(event.ReplyToken, "好的，已取消。")
+	}
+
+	log.Printf("Unhandled postback action: %s", postback.Action)
+	return nil
+}
+
+// handleFollowEvent welcomes a new (or returning) user with usage tips and a
+// few pets to start with.
+func handleFollowEvent(ctx context.Context, event *linebot.Event) error {
+	log.Printf("Followed by %s", event.Source.UserID)
+	messages := []linebot.SendingMessage{linebot.NewTextMessage(welcomeMessage + "\n\n" + helpMessage)}
+	if pets := samplePets(4); len(pets) > 0 {
+		messages = append(messages, newCarouselMessage("先來看看這些等待認養的寵物", petBubbles(ctx, pets, createFavoriteButton)).
+			WithQuickReplies(searchSuggestions(nil)))
 	}
+	_, err := bot.ReplyMessage(event.ReplyToken, messages...).Do()
+	return err
+}
 
+// handleUnfollowEvent drops what is kept for a user who blocked the bot. Their
+// favourites and settings are kept so they are back if the user returns.
+func handleUnfollowEvent(ctx context.Context, event *linebot.Event) error {
+	userID := event.Source.UserID
+	log.Printf("Unfollowed by %s", userID)
+	conversations.Forget(userID)
+	if err := subscriptionStore.ClearSubscriptions(ctx, userID); err != nil {
+		return fmt.Errorf("failed to clear subscriptions for %s: %w", userID, err)
+	}
 	return nil
 }
 
-func handleUnsendEvent(event *linebot.Event) error {
-	log.Printf("Unsend event from: %+v", event.Source)
-	target := event.Source.GroupID
-	if target == "" {
-		target = event.Source.RoomID
+// handleLeaveEvent drops the shortlist and search of a group the bot was
+// removed from.
+func handleLeaveEvent(ctx context.Context, event *linebot.Event) error {
+	groupID := chatID(event.Source)
+	log.Printf("Removed from %s", groupID)
+	conversations.Forget(groupID)
+	if err := groupStore.ClearShortlist(ctx, groupID); err != nil {
+		return fmt.Errorf("failed to clear shortlist for %s: %w", groupID, err)
 	}
-	// Note: Pushing to a user ID from an unsend event is not directly supported.
-	// This will only work in groups/rooms where the bot remains.
-	if target != "" {
-		if _, err := bot.PushMessage(target, linebot.NewTextMessage("不要害羞地回收訊息，趕快打狗或是貓來看流浪動物。")).Do(); err != nil {
-			return err
+	return nil
+}
+
+func handleJoinEvent(event *linebot.Event) error {
+	log.Printf("Joined %+v", event.Source)
+	_, err := bot.ReplyMessage(event.ReplyToken, linebot.NewTextMessage(joinMessage).WithQuickReplies(
+		groupSuggestions())).Do()
+	return err
+}
+
+// samplePets returns up to n pets, alternating dogs and cats.
+func samplePets(n int) []*Pet {
+	var pets []*Pet
+	seen := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		var pet *Pet
+		if i%2 == 0 {
+			pet = PetDB.GetNextDog()
+		} else {
+			pet = PetDB.GetNextCat()
+		}
+		if pet != nil && !seen[pet.ID] {
+			seen[pet.ID] = true
+			pets = append(pets, pet)
 		}
 	}
-	return nil
+	return pets
 }
 
 // --- Command Handler ---
@@ -205,193 +418,512 @@ func handleUnsendEvent(event *linebot.Event) error {
 func handleCommand(ctx context.Context, replyToken, userID, text string) bool {
 	switch {
 	case strings.HasPrefix(text, "favorite"):
+		// Sent by cards from before the buttons used postbacks.
 		petIDStr := strings.TrimSpace(strings.TrimPrefix(text, "favorite"))
-		if err := handleAddFavorite(ctx, replyToken, userID, petIDStr); err != nil {
+		petID, err := strconv.Atoi(petIDStr)
+		if err != nil {
+			log.Printf("Invalid pet ID in favorite command: %s", petIDStr)
+			return true
+		}
+		if err := handleAddFavorite(ctx, replyToken, userID, petID); err != nil {
 			log.Printf("Error handling favorite command: %v", err)
 		}
 		return true
 	case text == "狗" || text == "dog":
-		return replyWithSinglePet(replyToken, PetDB.GetNextDog()) == nil
+		return showPet(ctx, replyToken, userID, PetDB.GetNextDog()) == nil
 	case text == "貓" || text == "cat":
-		return replyWithSinglePet(replyToken, PetDB.GetNextCat()) == nil
+		return showPet(ctx, replyToken, userID, PetDB.GetNextCat()) == nil
 	case text == "收藏":
 		if err := handleShowFavorites(ctx, replyToken, userID); err != nil {
 			log.Printf("Error handling show favorites command: %v", err)
 		}
 		return true
+	case text == "清空收藏":
+		if err := replyWithClearFavoritesConfirm(replyToken); err != nil {
+			log.Printf("Error handling clear favorites command: %v", err)
+		}
+		return true
+	case text == helpCommand:
+		if err := replyWithText(replyToken, helpMessage); err != nil {
+			log.Printf("Error handling help command: %v", err)
+		}
+		return true
+	case text == settingsCommand:
+		if err := handleShowSettings(ctx, replyToken, userID); err != nil {
+			log.Printf("Error handling settings command: %v", err)
+		}
+		return true
+	case text == nearbyCommand:
+		if err := replyWithLocationRequest(replyToken); err != nil {
+			log.Printf("Error handling nearby command: %v", err)
+		}
+		return true
+	case text == "關閉通知" || text == "開啟通知":
+		if err := handleSetNotifications(ctx, replyToken, userID, text == "關閉通知"); err != nil {
+			log.Printf("Error handling notification command: %v", err)
+		}
+		return true
 	}
 	return false
 }
 
-// --- Action Handlers ---
-
-func handleAddFavorite(ctx context.Context, replyToken, userID, petIDStr string) error {
-	petID, err := strconv.Atoi(petIDStr)
+// handleSubscriptionCommand handles the saved search commands and reports
+// whether text was one of them.
+func handleSubscriptionCommand(ctx context.Context, replyToken, userID, text string) bool {
+	var err error
+	switch {
+	case text == showSubscriptionsCommand:
+		err = handleShowSubscriptions(ctx, replyToken, userID)
+	case strings.HasPrefix(text, cancelSubscriptionCommand):
+		err = handleCancelSubscription(ctx, replyToken, userID, strings.TrimSpace(strings.TrimPrefix(text, cancelSubscriptionCommand)))
+	case strings.HasPrefix(text, subscribeCommand):
+		err = handleSubscribe(ctx, replyToken, userID, strings.TrimSpace(strings.TrimPrefix(text, subscribeCommand)))
+	default:
+		return false
+	}
 	if err != nil {
-		return fmt.Errorf("invalid pet ID: %s", petIDStr)
+		log.Printf("Error handling subscription command: %v", err)
 	}
+	return true
+}
+
+// --- Action Handlers ---
+
+func handleAddFavorite(ctx context.Context, replyToken, userID string, petID int) error {
 	pet := PetDB.GetPet(petID)
 	if pet == nil {
 		return fmt.Errorf("pet with ID %d not found", petID)
 	}
 
-	if err := addFavorite(ctx, userID, pet); err != nil {
-		return replyWithError(replyToken, "加入收藏失敗，請稍後再試。")
+	conversations.SelectPet(userID, pet.ID)
+	if err := favoriteStore.AddFavorite(ctx, userID, pet.ID); err != nil {
+		log.Printf("Error adding favorite: %v", err)
+		return replyWithText(replyToken, "加入收藏失敗，請稍後再試。")
 	}
+	log.Printf("User %s favorited pet %d", userID, pet.ID)
 
-	_, err = bot.ReplyMessage(replyToken, linebot.NewTextMessage("已將寵物加入您的收藏！")).Do()
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("已將寵物加入您的收藏！")).Do()
 	return err
 }
 
 func handleShowFavorites(ctx context.Context, replyToken, userID string) error {
-	favs, err := getFavorites(ctx, userID)
+	favs, err := favoriteStore.Favorites(ctx, userID)
 	if err != nil {
-		return replyWithError(replyToken, "抱歉，讀取收藏清單時發生錯誤。")
+		log.Printf("Error getting favorites: %v", err)
+		return replyWithText(replyToken, "抱歉，讀取收藏清單時發生錯誤。")
 	}
-	return replyWithPetCarousel(replyToken, favs, "您的收藏清單")
+	if len(favs) == 0 {
+		return replyWithText(replyToken, "您的收藏清單是空的，看到喜歡的寵物可以按「加入收藏」。")
+	}
+
+	// Favourites only hold the animal ID; show the current record from the
+	// catalogue, or mark the animal as gone if it is no longer listed.
+	if len(favs) > 10 { // Carousel limit is 10
+		favs = favs[:10]
+	}
+	var pets []*Pet
+	for _, fav := range favs {
+		if pet := PetDB.GetPet(fav.PetID); pet != nil {
+			pets = append(pets, pet)
+		}
+	}
+	imageURLs := resolveImageURLs(ctx, pets, previewVariant)
+
+	var bubbles []*linebot.BubbleContainer
+	for _, fav := range favs {
+		pet := PetDB.GetPet(fav.PetID)
+		if pet == nil {
+			bubbles = append(bubbles, newUnavailablePetBubble(fav.PetID))
+			continue
+		}
+		bubbles = append(bubbles, newPetBubble(newPetView(pet, imageURLs[pet.ID], petProfiles.Cached(pet)), createRemoveFavoriteButton(pet)))
+	}
+	return replyWithBubbles(replyToken, bubbles, "您的收藏清單")
 }
 
-// --- Reply Helpers ---
+func handleRemoveFavorite(ctx context.Context, replyToken, userID string, petID int) error {
+	if err := favoriteStore.RemoveFavorite(ctx, userID, petID); err != nil {
+		log.Printf("Error removing favorite: %v", err)
+		return replyWithText(replyToken, "移除收藏失敗，請稍後再試。")
+	}
+	log.Printf("User %s unfavorited pet %d", userID, petID)
+
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("已將寵物從您的收藏中移除。")).Do()
+	return err
+}
 
-func replyWithSinglePet(replyToken string, pet *Pet) error {
+// similarCriteria describes pet's looks in the words Gemini uses, such as 小型
+// rather than SMALL, so they can be shown and refined like any other search.
+func similarCriteria(pet *Pet) *SearchCriteria {
+	return &SearchCriteria{
+		Kind:     pet.Variety,
+		BodyType: label(bodyTypeLabels, pet.Type),
+		Color:    strings.TrimSuffix(pet.HairType, "色"),
+	}
+}
+
+// handleMoreLikeThis shows pets that look like the given one and keeps its
+// traits as the search criteria, so the user can refine them.
+func handleMoreLikeThis(ctx context.Context, replyToken, userID string, petID int) error {
+	pet := PetDB.GetPet(petID)
 	if pet == nil {
-		_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("抱歉，目前沒有找到寵物。")).Do()
-		return err
+		return replyWithText(replyToken, "這隻寵物已不在認養名單中，換個條件找找看吧！")
+	}
+
+	criteria := similarCriteria(pet)
+	conversations.SetCriteria(userID, criteria)
+	similar := withoutPet(findSimilarPets(PetDB, criteria), pet.ID)
+	if len(similar) == 0 {
+		// Nothing else shares its looks; fall back to the same kind.
+		similar = withoutPet(PetDB.SearchPets(&SearchCriteria{Kind: pet.Variety}), pet.ID)
+	}
+	return replyWithSearchResults(ctx, replyToken, similar, "和"+pet.Name+"相似的寵物", criteria)
+}
+
+func withoutPet(pets []*Pet, petID int) []*Pet {
+	var rest []*Pet
+	for _, p := range pets {
+		if p.ID != petID {
+			rest = append(rest, p)
+		}
+	}
+	return rest
+}
+
+func handleShelterInfo(ctx context.Context, replyToken, userID string, petID int) error {
+	pet := PetDB.GetPet(petID)
+	if pet == nil {
+		return replyWithText(replyToken, "這隻寵物已不在認養名單中，無法查詢收容所資訊。")
+	}
+	conversations.SelectPet(userID, pet.ID)
+
+	message := fmt.Sprintf("收容所：%s\n聯絡電話：%s\n目前這裡有 %d 隻動物等待認養。\n\n前往前請先來電確認開放時間，並告知動物編號 %s。",
+		pet.Resettlement, pet.Phone, PetDB.CountAtShelter(pet.Resettlement), pet.Name)
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(message)).Do()
+	return err
+}
+
+// handlePetDetails replies with the pet's full record and buttons to call,
+// find or look up the shelter.
+func handlePetDetails(ctx context.Context, replyToken string, source *linebot.EventSource, petID int) error {
+	pet := PetDB.GetPet(petID)
+	if pet == nil {
+		return replyWithText(replyToken, "這隻寵物已不在認養名單中，可能已經找到新家了。")
 	}
-	if len(pet.ImageName) > 0 {
-		pet.ImageName = getSecureImageAddress(pet.ImageName)
+	primaryButton := createFavoriteButton(pet)
+	if groupID := chatID(source); groupID != "" {
+		primaryButton = createShortlistButton(pet)
+	} else {
+		conversations.SelectPet(source.UserID, pet.ID)
 	}
-	flexMessage := newPetFlexMessage(pet)
-	_, err := bot.ReplyMessage(replyToken, flexMessage).Do()
+
+	imageURL := resolveImageURLs(ctx, []*Pet{pet}, heroVariant)[pet.ID]
+	view := newPetView(pet, imageURL, petProfiles.Cached(pet))
+	bubble := renderPetBubble(detailBubbleTemplate, view, primaryButton)
+	_, err := bot.ReplyMessage(replyToken, linebot.NewFlexMessage(pet.Name+"的詳細資料", bubble)).Do()
 	return err
 }
 
-func replyWithPetCarousel(replyToken string, pets []*Pet, title string) error {
-	if len(pets) == 0 {
-		_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("很抱歉，目前沒有找到符合條件的寵物。")).Do()
-		return err
+func handleClearFavorites(ctx context.Context, replyToken, userID string) error {
+	if err := favoriteStore.ClearFavorites(ctx, userID); err != nil {
+		log.Printf("Error clearing favorites: %v", err)
+		return replyWithText(replyToken, "清空收藏失敗，請稍後再試。")
 	}
+	log.Printf("User %s cleared favorites", userID)
 
-	var bubbles []*linebot.BubbleContainer
-	for i, p := range pets {
-		if i >= 10 { // Carousel limit is 10
-			break
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("已清空您的收藏清單。")).Do()
+	return err
+}
+
+func handleSetNotifications(ctx context.Context, replyToken, userID string, muted bool) error {
+	if err := settingsStore.SetNotificationsMuted(ctx, userID, muted); err != nil {
+		log.Printf("Error saving notification setting: %v", err)
+		return replyWithText(replyToken, "設定失敗，請稍後再試。")
+	}
+	message := "已開啟通知，收藏的寵物或訂閱的條件有新消息時會通知您。"
+	if muted {
+		message = "已關閉通知，輸入「開啟通知」可以重新開啟。"
+	}
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(message)).Do()
+	return err
+}
+
+// handleSubscribe saves query as a subscription, or the user's current search
+// criteria when query is empty.
+func handleSubscribe(ctx context.Context, replyToken, userID, query string) error {
+	criteria := conversations.Criteria(userID)
+	if query != "" {
+		conversations.Reset(userID)
+		var err error
+		criteria, err = refineSearch(ctx, userID, query)
+		if errors.Is(err, errGeminiQuota) {
+			return replyWithText(replyToken, quotaExceededMessage)
 		}
-		if len(p.ImageName) > 0 {
-			p.ImageName = getSecureImageAddress(p.ImageName)
+		if err != nil {
+			log.Printf("Gemini parsing error: %v", err)
 		}
-		bubbles = append(bubbles, newPetFlexMessage(p).Contents.(*linebot.BubbleContainer))
+	}
+	if criteria.IsEmpty() {
+		return replyWithText(replyToken, "請先告訴我想找什麼樣的寵物，例如「訂閱 台北的小型母狗」。")
 	}
 
-	carousel := &linebot.CarouselContainer{
-		Type:     linebot.FlexContainerTypeCarousel,
-		Contents: bubbles,
+	subs, err := subscriptionStore.Subscriptions(ctx, userID)
+	if err != nil {
+		log.Printf("Error getting subscriptions: %v", err)
+		return replyWithText(replyToken, "訂閱失敗，請稍後再試。")
+	}
+	for _, sub := range subs {
+		if sub.Criteria == *criteria {
+			return replyWithText(replyToken, fmt.Sprintf("您已經訂閱過「%s」了。", describeCriteria(criteria)))
+		}
+	}
+	if len(subs) >= maxSubscriptions {
+		return replyWithText(replyToken, fmt.Sprintf("最多只能訂閱 %d 個條件，請先輸入「%s」整理一下。", maxSubscriptions, showSubscriptionsCommand))
 	}
+	if err := subscriptionStore.AddSubscription(ctx, userID, *criteria); err != nil {
+		log.Printf("Error adding subscription: %v", err)
+		return replyWithText(replyToken, "訂閱失敗，請稍後再試。")
+	}
+	log.Printf("User %s subscribed to %+v", userID, criteria)
 
-	_, err := bot.ReplyMessage(replyToken, linebot.NewFlexMessage(title, carousel)).Do()
+	message := fmt.Sprintf("已訂閱「%s」，有新的寵物符合條件時會通知您。目前有 %d 隻符合。\n輸入「%s」查看或取消。",
+		describeCriteria(criteria), len(PetDB.SearchPets(criteria)), showSubscriptionsCommand)
+	_, err = bot.ReplyMessage(replyToken, linebot.NewTextMessage(message)).Do()
 	return err
 }
 
-func replyWithError(replyToken, message string) error {
-	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(message)).Do()
+func handleShowSubscriptions(ctx context.Context, replyToken, userID string) error {
+	subs, err := subscriptionStore.Subscriptions(ctx, userID)
+	if err != nil {
+		log.Printf("Error getting subscriptions: %v", err)
+		return replyWithText(replyToken, "抱歉，讀取訂閱時發生錯誤。")
+	}
+	if len(subs) == 0 {
+		return replyWithText(replyToken, "您還沒有訂閱任何條件，搜尋後輸入「訂閱」，有新的寵物時就會通知您。")
+	}
+	_, err = bot.ReplyMessage(replyToken, linebot.NewTextMessage(subscriptionList(subs))).Do()
 	return err
 }
 
-// --- Firebase Operations ---
+// handleCancelSubscription cancels the subscription numbered as in
+// 我的訂閱, or all of them when number is empty.
+func handleCancelSubscription(ctx context.Context, replyToken, userID, number string) error {
+	if number == "" {
+		if err := subscriptionStore.ClearSubscriptions(ctx, userID); err != nil {
+			log.Printf("Error clearing subscriptions: %v", err)
+			return replyWithText(replyToken, "取消訂閱失敗，請稍後再試。")
+		}
+		log.Printf("User %s cleared subscriptions", userID)
+		_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("已取消所有訂閱。")).Do()
+		return err
+	}
 
-func addFavorite(ctx context.Context, userID string, pet *Pet) error {
-	ref := dbClient.NewRef("/petneedme/favorites/" + userID)
-	petRef := ref.Child(strconv.Itoa(pet.ID)) // Use pet ID as the key to avoid duplicates
-	if err := petRef.Set(ctx, pet); err != nil {
-		return fmt.Errorf("error adding favorite to Firebase for user %s: %w", userID, err)
+	subs, err := subscriptionStore.Subscriptions(ctx, userID)
+	if err != nil {
+		log.Printf("Error getting subscriptions: %v", err)
+		return replyWithText(replyToken, "取消訂閱失敗，請稍後再試。")
 	}
-	log.Printf("User %s favorited pet %d", userID, pet.ID)
-	return nil
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(subs) {
+		return replyWithText(replyToken, fmt.Sprintf("找不到這個編號，請輸入「%s」確認編號。", showSubscriptionsCommand))
+	}
+	sub := subs[n-1]
+	if err := subscriptionStore.RemoveSubscription(ctx, userID, sub.ID); err != nil {
+		log.Printf("Error removing subscription: %v", err)
+		return replyWithText(replyToken, "取消訂閱失敗，請稍後再試。")
+	}
+	log.Printf("User %s unsubscribed from %+v", userID, sub.Criteria)
+	_, err = bot.ReplyMessage(replyToken, linebot.NewTextMessage(fmt.Sprintf("已取消訂閱「%s」。", describeCriteria(&sub.Criteria)))).Do()
+	return err
 }
 
-func getFavorites(ctx context.Context, userID string) ([]*Pet, error) {
-	var favorites map[string]Pet
-	ref := dbClient.NewRef("/petneedme/favorites/" + userID)
-	if err := ref.Get(ctx, &favorites); err != nil {
-		return nil, fmt.Errorf("error getting favorites from Firebase for user %s: %w", userID, err)
+func handleShowSettings(ctx context.Context, replyToken, userID string) error {
+	muted, err := settingsStore.NotificationsMuted(ctx, userID)
+	if err != nil {
+		log.Printf("Error getting settings: %v", err)
+		return replyWithText(replyToken, "抱歉，讀取設定時發生錯誤。")
+	}
+	status, toggle := "已開啟", "關閉通知"
+	if muted {
+		status, toggle = "已關閉", "開啟通知"
+	}
+	buttons := linebot.NewButtonsTemplate("", "設定", "推播通知："+status,
+		linebot.NewMessageAction(toggle, toggle),
+		linebot.NewMessageAction(showSubscriptionsCommand, showSubscriptionsCommand),
+		linebot.NewMessageAction("清空收藏", "清空收藏"),
+	)
+	_, err = bot.ReplyMessage(replyToken, linebot.NewTemplateMessage("設定", buttons)).Do()
+	return err
+}
+
+// handleLocationMessage shows pets at shelters in the city or county of the
+// shared location, keeping the user's other search criteria.
+func handleLocationMessage(ctx context.Context, event *linebot.Event, msg *linebot.LocationMessage) error {
+	city := cityFromAddress(msg.Address)
+	if city == "" {
+		return replyWithText(event.ReplyToken, "抱歉，無法辨識這個位置所在的縣市，請直接輸入想找的縣市，例如「台中的狗」。")
+	}
+	criteria := conversations.Criteria(event.Source.UserID)
+	if criteria.IsEmpty() {
+		// Without a search, look for the kind of pet the user was just viewing.
+		if pet := PetDB.GetPet(conversations.SelectedPet(event.Source.UserID)); pet != nil {
+			criteria = &SearchCriteria{Kind: pet.Variety}
+		}
+	}
+	criteria = criteria.Apply(&SearchCriteria{Location: city})
+	conversations.SetCriteria(event.Source.UserID, criteria)
+	return replyWithSearchResults(ctx, event.ReplyToken, PetDB.SearchPets(criteria), city+"的寵物", criteria)
+}
+
+func handleQuestion(ctx context.Context, replyToken, userID, question string) error {
+	var pet *Pet
+	if id := conversations.SelectedPet(userID); id != 0 {
+		pet = PetDB.GetPet(id)
+	}
+	answer, err := answerQuestion(ctx, questionAnswerer, pet, question)
+	if errors.Is(err, errGeminiQuota) {
+		return replyWithText(replyToken, quotaExceededMessage)
+	}
+	if err != nil {
+		log.Printf("Gemini answer error: %v", err)
+		return replyWithText(replyToken, "抱歉，目前無法回答您的問題，請稍後再試。")
+	}
+	_, err = bot.ReplyMessage(replyToken, linebot.NewTextMessage(answer)).Do()
+	return err
+}
+
+// --- Reply Helpers ---
+
+// showPet replies with a single pet and remembers it as the one the user is
+// asking about.
+func showPet(ctx context.Context, replyToken, userID string, pet *Pet) error {
+	if pet != nil {
+		conversations.SelectPet(userID, pet.ID)
 	}
-	if favorites == nil {
-		return []*Pet{}, nil
+	return replyWithSinglePet(ctx, replyToken, pet, createFavoriteButton, petSuggestions(pet, conversations.Criteria(userID)))
+}
+
+func replyWithSinglePet(ctx context.Context, replyToken string, pet *Pet, primaryButton func(*Pet) *linebot.ButtonComponent, suggestions *linebot.QuickReplyItems) error {
+	if pet == nil {
+		_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("抱歉，目前沒有找到寵物。").WithQuickReplies(suggestions)).Do()
+		return err
 	}
-	favsSlice := make([]*Pet, 0, len(favorites))
-	for _, pet := range favorites {
-		p := pet // Create a new variable to avoid pointer issues in loops
-		favsSlice = append(favsSlice, &p)
+	// A cold profile would hold the reply for a whole Gemini call, so it is
+	// generated in the background and shown the next time the pet comes up.
+	flexMessage := newPetFlexMessage(ctx, pet, petProfiles.Cached(pet), primaryButton(pet))
+	_, err := bot.ReplyMessage(replyToken, flexMessage.WithQuickReplies(suggestions)).Do()
+	return err
+}
+
+// replyWithSearchResults replies with the pets found for criteria and
+// suggests how to refine them.
+func replyWithSearchResults(ctx context.Context, replyToken string, pets []*Pet, title string, criteria *SearchCriteria) error {
+	return replyWithCarousel(ctx, replyToken, pets, title, createFavoriteButton, searchSuggestions(criteria))
+}
+
+// replyWithCarousel replies with up to ten pet cards, using primaryButton for
+// the main action on each card.
+func replyWithCarousel(ctx context.Context, replyToken string, pets []*Pet, title string, primaryButton func(*Pet) *linebot.ButtonComponent, suggestions *linebot.QuickReplyItems) error {
+	if len(pets) == 0 {
+		_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage("很抱歉，目前沒有找到符合條件的寵物。").WithQuickReplies(suggestions)).Do()
+		return err
 	}
-	return favsSlice, nil
+
+	message := newCarouselMessage(title, petBubbles(ctx, pets, primaryButton)).WithQuickReplies(suggestions)
+	_, err := bot.ReplyMessage(replyToken, message).Do()
+	return err
+}
+
+func replyWithBubbles(replyToken string, bubbles []*linebot.BubbleContainer, title string) error {
+	_, err := bot.ReplyMessage(replyToken, newCarouselMessage(title, bubbles)).Do()
+	return err
+}
+
+func replyWithLocationRequest(replyToken string) error {
+	message := linebot.NewTextMessage("請分享您的位置，我會幫您找附近收容所的動物。").
+		WithQuickReplies(newQuickReplies().Location("傳送位置").Build())
+	_, err := bot.ReplyMessage(replyToken, message).Do()
+	return err
+}
+
+func replyWithClearFavoritesConfirm(replyToken string) error {
+	confirm := linebot.NewConfirmTemplate(
+		"確定要清空所有收藏嗎？",
+		linebot.NewPostbackAction("清空", Postback{Action: postbackClearFavorites}.Encode(), "", "清空收藏", "", ""),
+		linebot.NewPostbackAction("取消", Postback{Action: postbackCancel}.Encode(), "", "取消", "", ""),
+	)
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTemplateMessage("確定要清空所有收藏嗎？", confirm)).Do()
+	return err
+}
+
+// replyWithText replies with a plain text message.
+func replyWithText(replyToken, message string) error {
+	_, err := bot.ReplyMessage(replyToken, linebot.NewTextMessage(message)).Do()
+	return err
 }
 
 // --- Flex Message Builders ---
 
-func newPetFlexMessage(pet *Pet) *linebot.FlexMessage {
-	if pet.ImageName == "" {
-		// Use a placeholder image if none is available
-		pet.ImageName = "https://petneed.me/static/img/petNeedme_full_color.png"
+// petBubbles builds a card for each of the first ten pets, the carousel limit.
+func petBubbles(ctx context.Context, pets []*Pet, primaryButton func(*Pet) *linebot.ButtonComponent) []*linebot.BubbleContainer {
+	if len(pets) > 10 { // Carousel limit is 10
+		pets = pets[:10]
 	}
-	bubble := &linebot.BubbleContainer{
-		Type: linebot.FlexContainerTypeBubble,
-		Hero: &linebot.ImageComponent{
-			Type:        linebot.FlexComponentTypeImage,
-			URL:         pet.ImageName,
-			Size:        linebot.FlexImageSizeTypeFull,
-			AspectRatio: linebot.FlexImageAspectRatioType20to13,
-			AspectMode:  linebot.FlexImageAspectModeTypeCover,
-		},
-		Body: &linebot.BoxComponent{
-			Type:   linebot.FlexComponentTypeBox,
-			Layout: linebot.FlexBoxLayoutTypeVertical,
-			Contents: []linebot.FlexComponent{
-				&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: pet.Name, Weight: linebot.FlexTextWeightTypeBold, Size: linebot.FlexTextSizeTypeXl},
-				&linebot.BoxComponent{
-					Type:     linebot.FlexComponentTypeBox,
-					Layout:   linebot.FlexBoxLayoutTypeVertical,
-					Margin:   linebot.FlexComponentMarginTypeLg,
-					Spacing:  linebot.FlexComponentSpacingTypeSm,
-					Contents: createDetailRows(pet),
-				},
-			},
-		},
-		Footer: &linebot.BoxComponent{
-			Type:    linebot.FlexComponentTypeBox,
-			Layout:  linebot.FlexBoxLayoutTypeVertical,
-			Spacing: linebot.FlexComponentSpacingTypeSm,
-			Contents: []linebot.FlexComponent{
-				createFavoriteButton(pet),
-				createShareButton(pet),
-			},
-		},
+	imageURLs := resolveImageURLs(ctx, pets, previewVariant)
+	var bubbles []*linebot.BubbleContainer
+	for _, p := range pets {
+		// Only use cached profiles here; generating ten of them would miss the reply window.
+		bubbles = append(bubbles, newPetBubble(newPetView(p, imageURLs[p.ID], petProfiles.Cached(p)), primaryButton(p)))
 	}
-	return linebot.NewFlexMessage("寵物資訊", bubble)
+	return bubbles
 }
 
-func createDetailRows(pet *Pet) []linebot.FlexComponent {
-	return []linebot.FlexComponent{
-		createDetailRow("種類", pet.Variety),
-		createDetailRow("性別", pet.Sex),
-		createDetailRow("體型", pet.Type),
-		createDetailRow("毛色", pet.HairType),
-		createDetailRow("年紀", pet.Age),
-		createDetailRow("收容所", pet.Resettlement),
-		createDetailRow("聯絡電話", pet.Phone),
+func newCarouselMessage(title string, bubbles []*linebot.BubbleContainer) *linebot.FlexMessage {
+	carousel := &linebot.CarouselContainer{
+		Type:     linebot.FlexContainerTypeCarousel,
+		Contents: bubbles,
 	}
+	return linebot.NewFlexMessage(title, carousel)
+}
+
+func newPetFlexMessage(ctx context.Context, pet *Pet, profile string, primaryButton *linebot.ButtonComponent) *linebot.FlexMessage {
+	imageURL := resolveImageURLs(ctx, []*Pet{pet}, heroVariant)[pet.ID]
+	return linebot.NewFlexMessage("寵物資訊", newPetBubble(newPetView(pet, imageURL, profile), primaryButton))
 }
 
-func createDetailRow(title, value string) *linebot.BoxComponent {
-	if value == "" {
-		value = "不詳"
+// newPetBubble builds the card for a pet from the pet bubble template.
+func newPetBubble(pet PetView, primaryButton *linebot.ButtonComponent) *linebot.BubbleContainer {
+	return renderPetBubble(petBubbleTemplate, pet, primaryButton)
+}
+
+// newUnavailablePetBubble is shown for a favourite that is no longer listed,
+// usually because it has been adopted.
+func newUnavailablePetBubble(petID int) *linebot.BubbleContainer {
+	return renderPetBubble(unavailableBubbleTemplate, PetView{ID: petID}, createRemoveFavoriteButton(&Pet{ID: petID}))
+}
+
+// renderPetBubble renders the named template, falling back to a card with
+// just the name if the template fails on this pet.
+func renderPetBubble(name string, pet PetView, primaryButton *linebot.ButtonComponent) *linebot.BubbleContainer {
+	data, err := newFlexBubbleData(pet, primaryButton)
+	if err == nil {
+		var bubble *linebot.BubbleContainer
+		if bubble, err = renderBubble(flexTemplates, name, data); err == nil {
+			return bubble
+		}
 	}
-	return &linebot.BoxComponent{
-		Type:    linebot.FlexComponentTypeBox,
-		Layout:  linebot.FlexBoxLayoutTypeBaseline,
-		Spacing: linebot.FlexComponentSpacingTypeSm,
-		Contents: []linebot.FlexComponent{
-			&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: title, Color: "#aaaaaa", Size: linebot.FlexTextSizeTypeSm, Flex: linebot.IntPtr(2)},
-			&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: value, Wrap: true, Color: "#666666", Size: linebot.FlexTextSizeTypeSm, Flex: linebot.IntPtr(5)},
+	log.Printf("Failed to render %s for pet %d: %v", name, pet.ID, err)
+	return &linebot.BubbleContainer{
+		Type: linebot.FlexContainerTypeBubble,
+		Body: &linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: []linebot.FlexComponent{&linebot.TextComponent{Type: linebot.FlexComponentTypeText, Text: fmt.Sprintf("%s（編號 %d）", pet.Name, pet.ID), Wrap: true}},
+		},
+		Footer: &linebot.BoxComponent{
+			Type:     linebot.FlexComponentTypeBox,
+			Layout:   linebot.FlexBoxLayoutTypeVertical,
+			Contents: []linebot.FlexComponent{primaryButton},
 		},
 	}
 }
@@ -400,23 +932,31 @@ func createFavoriteButton(pet *Pet) *linebot.ButtonComponent {
 	return &linebot.ButtonComponent{
 		Type:   linebot.FlexComponentTypeButton,
 		Style:  linebot.FlexButtonStyleTypePrimary,
-		Action: linebot.NewMessageAction("加入收藏", "favorite "+strconv.Itoa(pet.ID)),
+		Action: linebot.NewPostbackAction("加入收藏", Postback{Action: postbackFavorite, PetID: pet.ID}.Encode(), "", "加入收藏", "", ""),
 	}
 }
 
-func createShareButton(pet *Pet) *linebot.ButtonComponent {
-	shareText := generateShareText(pet)
-	shareURI := fmt.Sprintf("line://msg/text/?%s", url.QueryEscape(shareText))
+func createRemoveFavoriteButton(pet *Pet) *linebot.ButtonComponent {
 	return &linebot.ButtonComponent{
 		Type:   linebot.FlexComponentTypeButton,
-		Style:  linebot.FlexButtonStyleTypeLink,
-		Action: linebot.NewURIAction("分享給好友", shareURI),
+		Style:  linebot.FlexButtonStyleTypeSecondary,
+		Action: linebot.NewPostbackAction("移除收藏", Postback{Action: postbackUnfavorite, PetID: pet.ID}.Encode(), "", "移除收藏", "", ""),
 	}
 }
 
-func generateShareText(pet *Pet) string {
+// generateShareText is the message a user sends to share a pet. With a
+// public page the link shows a preview; otherwise the details are spelled out.
+func generateShareText(pet PetView) string {
+	intro := ""
+	if pet.Profile != "" {
+		intro = truncateText(pet.Profile, maxShareProfile) + "\n\n"
+	}
+	if pet.PageURL != "" {
+		return fmt.Sprintf("我想跟你分享一隻等待認養的%s「%s」！\n\n%s%s", pet.Kind, pet.Name, intro, pet.PageURL)
+	}
 	return fmt.Sprintf(
 		"我想跟你分享一個可愛的寵物！\n\n"+
+			"%s"+
 			"名字：%s\n"+
 			"種類：%s\n"+
 			"性別：%s\n"+
@@ -425,35 +965,37 @@ func generateShareText(pet *Pet) string {
 			"收容所：%s\n"+
 			"聯絡電話：%s\n\n"+
 			"看看牠的照片吧：%s",
-		pet.Name, pet.Variety, pet.Sex, pet.Type, pet.Age, pet.Resettlement, pet.Phone, pet.ImageName,
+		intro, pet.Name, pet.Kind, pet.Sex, pet.BodyType, pet.Age, pet.Shelter, pet.Phone, pet.ImageURL,
 	)
 }
 
-// --- Utilities ---
-
-func getSecureImageAddress(oriAdd string) string {
-	if ImgSrv == "" || oriAdd == "" {
-		return ""
-	}
-	eURL := url.QueryEscape(oriAdd)
-	imgGetURL := fmt.Sprintf("%surl?%s", ImgSrv, eURL)
-
-	response, err := http.Get(imgGetURL)
-	if err != nil {
-		log.Printf("Error downloading image address from %s: %v", imgGetURL, err)
-		return ""
+// shareURI returns the link that opens LINE with the share text filled in.
+// LINE rejects the whole reply if an action's URI is over maxURILength, so a
+// text that escapes to more drops the profile and is then cut short.
+func shareURI(pet PetView) string {
+	const prefix = "line://msg/text/?"
+	uri := prefix + url.QueryEscape(generateShareText(pet))
+	if len(uri) <= maxURILength {
+		return uri
+	}
+	pet.Profile = ""
+	text := []rune(generateShareText(pet))
+	for {
+		uri = prefix + url.QueryEscape(string(text)+"…")
+		if len(uri) <= maxURILength || len(text) == 0 {
+			return uri
+		}
+		text = text[:len(text)-1]
 	}
-	defer response.Body.Close()
+}
 
-	if response.StatusCode != http.StatusOK {
-		log.Printf("Bad status code from image server: %d", response.StatusCode)
-		return ""
+// truncateText shortens s to at most n characters, marking the cut with an
+// ellipsis.
+func truncateText(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
 	}
-
-	totalBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		log.Printf("Error reading image server response: %v", err)
-		return ""
-	}
-	return fmt.Sprintf("%simgs?%s.jpg", ImgSrv, string(totalBody))
+	return s
 }
+
+// --- Utilities ---
@@ -0,0 +1,117 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+func TestMain(m *testing.M) {
+	// Load what main loads at startup: the card templates and adoption rules.
+	if err := initializeFlexTemplates(); err != nil {
+		log.Fatalf("Failed to load flex templates: %v", err)
+	}
+	if err := initializeKnowledge(); err != nil {
+		log.Fatalf("Failed to load adoption knowledge: %v", err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestSamplePets(t *testing.T) {
+	saved := PetDB
+	defer func() { PetDB = saved }()
+	PetDB = newTestPets()
+
+	pets := samplePets(4)
+	if len(pets) < 2 || pets[0].Variety != "狗" || pets[1].Variety != "貓" {
+		t.Fatalf("Expected a dog then a cat, got %+v", pets)
+	}
+	seen := make(map[int]bool)
+	for _, pet := range pets {
+		if seen[pet.ID] {
+			t.Errorf("Pet %d was sampled twice", pet.ID)
+		}
+		seen[pet.ID] = true
+	}
+}
+
+func TestSamplePetsAdvancesOnlyTheKindUsed(t *testing.T) {
+	saved := PetDB
+	defer func() { PetDB = saved }()
+	PetDB = new(Pets)
+	PetDB.LoadPets(TaiwanPets{
+		{AnimalID: 1, AnimalKind: "狗"},
+		{AnimalID: 2, AnimalKind: "貓"},
+		{AnimalID: 3, AnimalKind: "狗"},
+		{AnimalID: 4, AnimalKind: "貓"},
+	})
+
+	pets := samplePets(2)
+	if len(pets) != 2 || pets[0].ID != 1 || pets[1].ID != 2 {
+		t.Fatalf("Expected the first dog and cat, got %+v", pets)
+	}
+	if dog := PetDB.GetNextDog(); dog == nil || dog.ID != 3 {
+		t.Errorf("Expected the next dog to be 3, got %+v", dog)
+	}
+}
+
+func TestGroupsIgnorePhotosAndLocations(t *testing.T) {
+	// Any reply would go through the nil test bot and panic.
+	source := &linebot.EventSource{Type: linebot.EventSourceTypeGroup, GroupID: "G1", UserID: "U1"}
+	for _, msg := range []linebot.Message{
+		&linebot.ImageMessage{ID: "1"},
+		&linebot.LocationMessage{Address: "臺北市信義區市府路1號"},
+	} {
+		event := &linebot.Event{Type: linebot.EventTypeMessage, ReplyToken: "r", Source: source, Message: msg}
+		if err := handleMessageEvent(context.Background(), event); err != nil {
+			t.Errorf("Expected %T to be ignored, got %v", msg, err)
+		}
+	}
+	if conversations.Criteria("U1") != nil {
+		t.Error("Expected no conversation state for a group member")
+	}
+}
+
+func TestSimilarCriteria(t *testing.T) {
+	pet := &Pet{ID: 1, Variety: "狗", Type: "SMALL", HairType: "黑白色"}
+	c := similarCriteria(pet)
+	if *c != (SearchCriteria{Kind: "狗", BodyType: "小型", Color: "黑白"}) {
+		t.Errorf("Unexpected criteria %+v", c)
+	}
+	if !c.Matches(pet) {
+		t.Error("Expected the criteria to match the pet they came from")
+	}
+	if refined := c.Overlay(&SearchCriteria{BodyType: "大型"}); refined.BodyType != "大型" || refined.Color != "黑白" {
+		t.Errorf("Expected a refinement to replace the size, got %+v", refined)
+	}
+}
+
+func TestChatID(t *testing.T) {
+	for _, tt := range []struct {
+		source linebot.EventSource
+		want   string
+	}{
+		{linebot.EventSource{Type: linebot.EventSourceTypeUser, UserID: "u1"}, ""},
+		{linebot.EventSource{Type: linebot.EventSourceTypeGroup, UserID: "u1", GroupID: "g1"}, "g1"},
+		{linebot.EventSource{Type: linebot.EventSourceTypeRoom, UserID: "u1", RoomID: "r1"}, "r1"},
+	} {
+		if got := chatID(&tt.source); got != tt.want {
+			t.Errorf("chatID(%+v) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
@@ -50,6 +50,14 @@ type Pet struct {
 	AnimalAnlong    string `json:"AnimalAnlong"`
 	Bodyweight      string `json:"Bodyweight"`
 	ImageName       string `json:"ImageName"`
+	Status          string `json:"Status"`
+	Updated         string `json:"Updated"`
+	Bacterin        string `json:"Bacterin"`
+	FoundPlace      string `json:"FoundPlace"`
+	IntakeDate      string `json:"IntakeDate"`
+	OpenDate        string `json:"OpenDate"`
+	ShelterName     string `json:"ShelterName"`
+	ShelterAddress  string `json:"ShelterAddress"`
 }
 
 //PetType :
@@ -71,3 +79,43 @@ func (p *Pet) PetType() PetType {
 func (p *Pet) DisplayPet() string {
 	return fmt.Sprintf("快來看看這隻可愛的%s！\n名字: %s\n收容所: %s\n聯絡電話: %s", p.Variety, p.Name, p.Resettlement, p.Phone)
 }
+
+//SterilizationText : Describe the sterilization status in Chinese
+func (p *Pet) SterilizationText() string {
+	switch p.IsSterilization {
+	case "T":
+		return "已絕育"
+	case "F":
+		return "未絕育"
+	default:
+		return "不詳"
+	}
+}
+
+//BacterinText : Describe the rabies vaccination in Chinese
+func (p *Pet) BacterinText() string {
+	switch p.Bacterin {
+	case "T":
+		return "已施打"
+	case "F":
+		return "未施打"
+	default:
+		return "不詳"
+	}
+}
+
+//StatusText : Describe the adoption status in Chinese
+func (p *Pet) StatusText() string {
+	switch p.Status {
+	case "OPEN":
+		return "開放認養"
+	case "ADOPTED":
+		return "已認養"
+	case "DEAD":
+		return "已死亡"
+	case "NONE":
+		return "未公告"
+	default:
+		return "其他"
+	}
+}
@@ -0,0 +1,159 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultQueryLimit = 20
+	maxQueryLimit     = 100
+)
+
+// PetQuery filters and pages the catalogue for the web pages and APIs. Its
+// fields hold open data codes, e.g. M or SMALL.
+type PetQuery struct {
+	Kind     string
+	Sex      string
+	BodyType string
+	Age      string
+	County   string
+	Shelter  string
+	Offset   int
+	Limit    int
+}
+
+// parsePetQuery reads a PetQuery from URL parameters: kind, sex, size, age,
+// county, shelter, offset and limit. The filters accept both codes and the
+// words shown to users, such as 母 or 小型.
+func parsePetQuery(params url.Values) (PetQuery, error) {
+	q := PetQuery{
+		Kind:     kind(params.Get("kind")),
+		Sex:      code(sexLabels, params.Get("sex")),
+		BodyType: code(bodyTypeLabels, params.Get("size")),
+		Age:      code(ageLabels, params.Get("age")),
+		County:   strings.TrimSpace(params.Get("county")),
+		Shelter:  strings.TrimSpace(params.Get("shelter")),
+		Limit:    defaultQueryLimit,
+	}
+	for name, dst := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		v := params.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || (name == "limit" && n == 0) {
+			return PetQuery{}, fmt.Errorf("invalid %s %q", name, v)
+		}
+		*dst = n
+	}
+	if q.Limit > maxQueryLimit {
+		q.Limit = maxQueryLimit
+	}
+	return q, nil
+}
+
+// kind accepts "dog" and "cat" as well as the open data's 狗 and 貓.
+func kind(value string) string {
+	switch value = strings.TrimSpace(value); strings.ToLower(value) {
+	case "dog":
+		return "狗"
+	case "cat":
+		return "貓"
+	}
+	return value
+}
+
+// code returns the open data code for a word shown to users, or the value
+// itself if it is already a code.
+func code(labels map[string]string, value string) string {
+	value = strings.TrimSpace(value)
+	for c, l := range labels {
+		if value == l {
+			return c
+		}
+	}
+	return strings.ToUpper(value)
+}
+
+// Matches reports whether pet passes every filter that is set.
+func (q PetQuery) Matches(pet *Pet) bool {
+	switch {
+	case q.Kind != "" && pet.Variety != q.Kind:
+		return false
+	case q.Sex != "" && pet.Sex != q.Sex:
+		return false
+	case q.BodyType != "" && pet.Type != q.BodyType:
+		return false
+	case q.Age != "" && pet.Age != q.Age:
+		return false
+	case q.County != "" && !strings.HasPrefix(normalizePlace(pet.ShelterAddress), normalizePlace(q.County)):
+		return false
+	case q.Shelter != "" && pet.ShelterName != q.Shelter:
+		return false
+	}
+	return true
+}
+
+// Query returns one page of the pets matching q, ranked like search results,
+// and how many match in total.
+func (p *Pets) Query(q PetQuery) ([]*Pet, int) {
+	var matched []*Pet
+	for _, pet := range p.All() {
+		if q.Matches(pet) {
+			matched = append(matched, pet)
+		}
+	}
+	matched = photos.Rank(matched)
+
+	total := len(matched)
+	if q.Offset >= total {
+		return nil, total
+	}
+	end := q.Offset + q.Limit
+	if end > total {
+		end = total
+	}
+	return matched[q.Offset:end], total
+}
+
+// ShelterSummary is a shelter and how many of its pets are listed.
+type ShelterSummary struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Phone   string `json:"phone"`
+	Count   int    `json:"count"`
+}
+
+// Shelters returns every shelter with listed pets, in the order first seen.
+func (p *Pets) Shelters() []ShelterSummary {
+	shelters := []ShelterSummary{}
+	index := make(map[string]int)
+	for _, pet := range p.All() {
+		if pet.ShelterName == "" {
+			continue
+		}
+		i, ok := index[pet.ShelterName]
+		if !ok {
+			i = len(shelters)
+			index[pet.ShelterName] = i
+			shelters = append(shelters, ShelterSummary{Name: pet.ShelterName, Address: pet.ShelterAddress, Phone: pet.Phone})
+		}
+		shelters[i].Count++
+	}
+	return shelters
+}
@@ -0,0 +1,85 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// withQueryPets points PetDB at pets in two shelters.
+func withQueryPets(t *testing.T) {
+	saved := PetDB
+	t.Cleanup(func() { PetDB = saved })
+	PetDB = new(Pets)
+	PetDB.LoadPets(TaiwanPets{
+		{AnimalID: 1, AnimalKind: "狗", AnimalSex: "M", AnimalBodytype: "SMALL", AnimalAge: "ADULT", ShelterName: "臺北市動物之家", ShelterAddress: "臺北市內湖區潭美街852號"},
+		{AnimalID: 2, AnimalKind: "狗", AnimalSex: "F", AnimalBodytype: "BIG", AnimalAge: "CHILD", ShelterName: "臺北市動物之家", ShelterAddress: "臺北市內湖區潭美街852號"},
+		{AnimalID: 3, AnimalKind: "貓", AnimalSex: "F", AnimalBodytype: "SMALL", AnimalAge: "CHILD", ShelterName: "臺中市動物之家南屯園區", ShelterAddress: "台中市南屯區中台路601號"},
+		{AnimalID: 4, AnimalKind: "狗", AnimalSex: "F", AnimalBodytype: "MEDIUM", AnimalAge: "ADULT", ShelterName: "臺中市動物之家南屯園區", ShelterAddress: "台中市南屯區中台路601號"},
+	})
+}
+
+func TestParsePetQuery(t *testing.T) {
+	q, err := parsePetQuery(url.Values{"kind": {"dog"}, "sex": {"母"}, "size": {"small"}, "age": {"成年"}, "county": {"台中"}, "offset": {"20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PetQuery{Kind: "狗", Sex: "F", BodyType: "SMALL", Age: "ADULT", County: "台中", Offset: 20, Limit: defaultQueryLimit}
+	if q != want {
+		t.Errorf("got %+v, want %+v", q, want)
+	}
+
+	if q, _ := parsePetQuery(url.Values{"limit": {"1000"}}); q.Limit != maxQueryLimit {
+		t.Errorf("Expected the limit to be capped at %d, got %d", maxQueryLimit, q.Limit)
+	}
+	for _, bad := range []url.Values{{"offset": {"-1"}}, {"limit": {"ten"}}, {"limit": {"0"}}} {
+		if _, err := parsePetQuery(bad); err == nil {
+			t.Errorf("Expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestPetsQuery(t *testing.T) {
+	withQueryPets(t)
+
+	ids := func(pets []*Pet) []int {
+		var ids []int
+		for _, p := range pets {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	for _, tt := range []struct {
+		query PetQuery
+		want  []int
+		total int
+	}{
+		{PetQuery{Kind: "狗", Limit: 10}, []int{1, 2, 4}, 3},
+		{PetQuery{Sex: "F", County: "臺中市", Limit: 10}, []int{3, 4}, 2},
+		{PetQuery{Shelter: "臺北市動物之家", Age: "CHILD", Limit: 10}, []int{2}, 1},
+		{PetQuery{Limit: 2, Offset: 1}, []int{2, 3}, 4},
+		{PetQuery{Limit: 2, Offset: 4}, nil, 4},
+	} {
+		pets, total := PetDB.Query(tt.query)
+		if got := ids(pets); total != tt.total || !slices.Equal(got, tt.want) {
+			t.Errorf("%+v: got %v of %d, want %v of %d", tt.query, got, total, tt.want, tt.total)
+		}
+	}
+
+	shelters := PetDB.Shelters()
+	if len(shelters) != 2 || shelters[0].Name != "臺北市動物之家" || shelters[0].Count != 2 || shelters[1].Count != 2 {
+		t.Errorf("Unexpected shelters %+v", shelters)
+	}
+}
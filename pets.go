@@ -17,10 +17,18 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 )
 
+// openDataPageSize is the number of records fetched per open data request.
+const openDataPageSize = 1000
+
+// openDataMaxPages bounds the catalogue fetch in case paging misbehaves.
+const openDataMaxPages = 50
+
 //Pets :All pet related API
 type Pets struct {
+	mu         sync.RWMutex
 	allPets    []Pet
 	queryIndex int
 }
@@ -34,44 +42,41 @@ func NewPets() *Pets {
 
 //GetNextPet :
 func (p *Pets) GetNextPet() *Pet {
-	if len(p.allPets) == 0 {
+	if p.GetPetsCount() == 0 {
 		p.getPets()
 	}
 
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.allPets) == 0 {
+		return nil
+	}
 	retPet := &p.allPets[p.getNextIndex()]
 	return retPet
 }
 
 //GetNextDog :
 func (p *Pets) GetNextDog() *Pet {
-	if len(p.allPets) == 0 {
-		p.getPets()
-	}
-
-	for {
-		q := p.GetNextPet()
-		if q == nil {
-			break
-		}
-		if q.PetType() == Dog {
-			return q
-		}
-	}
-	return nil
+	return p.getNextOfType(Dog)
 }
 
 //GetNextCat :
 func (p *Pets) GetNextCat() *Pet {
-	if len(p.allPets) == 0 {
+	return p.getNextOfType(Cat)
+}
+
+func (p *Pets) getNextOfType(t PetType) *Pet {
+	if p.GetPetsCount() == 0 {
 		p.getPets()
 	}
 
-	for {
+	// Look at each pet at most once so we stop if there is none of this type.
+	for i := p.GetPetsCount(); i > 0; i-- {
 		q := p.GetNextPet()
 		if q == nil {
 			break
 		}
-		if q.PetType() == Cat {
+		if q.PetType() == t {
 			return q
 		}
 	}
@@ -80,30 +85,69 @@ func (p *Pets) GetNextCat() *Pet {
 
 //GetPetsCount :
 func (p *Pets) GetPetsCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return len(p.allPets)
 }
 
 func (p *Pets) getPets() {
-	// We add $top and $skip to get data since the API might not return all data at once.
-	url := fmt.Sprintf("%s&$top=100&$skip=0", OpenDataURL)
-	c := NewClient(url)
-	body, err := c.GetHttpRes()
+	results, err := fetchPets()
 	if err != nil {
+		log.Printf("Failed to fetch pets: %v", err)
 		return
 	}
+	log.Println("All pets is :", len(results))
+	p.LoadPets(results)
+}
 
-	// log.Println("ret:", string(body))
-	var results TaiwanPets
-	err = json.Unmarshal(body, &results)
+// fetchPets downloads the whole catalogue from the open data API.
+func fetchPets() (TaiwanPets, error) {
+	var all TaiwanPets
+	for page := 0; page < openDataMaxPages; page++ {
+		// We add $top and $skip to get data since the API might not return all data at once.
+		url := fmt.Sprintf("%s&$top=%d&$skip=%d", OpenDataURL, openDataPageSize, page*openDataPageSize)
+		c := NewClient(url)
+		body, err := c.GetHttpRes()
+		if err != nil {
+			return nil, err
+		}
 
+		var results TaiwanPets
+		if err := json.Unmarshal(body, &results); err != nil {
+			return nil, fmt.Errorf("failed to parse open data: %w", err)
+		}
+		all = append(all, results...)
+		if len(results) < openDataPageSize {
+			break
+		}
+	}
+	return all, nil
+}
+
+// Refresh reloads the catalogue and returns what changed since the last load.
+func (p *Pets) Refresh() (*CatalogueDiff, error) {
+	results, err := fetchPets()
 	if err != nil {
-		//error
-		log.Fatal(err)
+		return nil, err
 	}
-	log.Println("All pets is :", len(results))
-	p.LoadPets(results)
+	if len(results) == 0 {
+		// An empty response is far more likely an API hiccup than every
+		// animal being adopted at once.
+		return nil, fmt.Errorf("open data returned no pets")
+	}
+	fresh := mapPets(results)
+
+	p.mu.Lock()
+	old := p.allPets
+	p.allPets = fresh
+	p.mu.Unlock()
+
+	diff := diffPets(old, fresh)
+	log.Printf("Refreshed pets: %d total, %d added, %d removed, %d changed", len(fresh), len(diff.Added), len(diff.Removed), len(diff.Changed))
+	return diff, nil
 }
 
+// getNextIndex advances the cursor. p.mu must be held.
 func (p *Pets) getNextIndex() int {
 	if p.queryIndex >= len(p.allPets) {
 		p.queryIndex = 0
@@ -115,6 +159,15 @@ func (p *Pets) getNextIndex() int {
 }
 
 func (p *Pets) LoadPets(pets TaiwanPets) {
+	mapped := mapPets(pets)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.allPets = append(p.allPets, mapped...)
+}
+
+func mapPets(pets TaiwanPets) []Pet {
+	allPets := make([]Pet, 0, len(pets))
 	//Mapping
 	for _, v := range pets {
 		pt := Pet{}
@@ -129,52 +182,98 @@ func (p *Pets) LoadPets(pets TaiwanPets) {
 		pt.Sex = v.AnimalSex
 		pt.Note = v.AnimalRemark
 		pt.Age = v.AnimalAge
-		p.allPets = append(p.allPets, pt)
+		pt.IsSterilization = v.AnimalSterilization
+		pt.Status = v.AnimalStatus
+		pt.Updated = v.AnimalUpdate
+		pt.AcceptNum = v.AnimalSubid
+		pt.Bacterin = v.AnimalBacterin
+		pt.FoundPlace = v.AnimalFoundplace
+		pt.IntakeDate = v.AnimalCreatetime
+		pt.OpenDate = v.AnimalOpendate
+		pt.ShelterName = v.ShelterName
+		pt.ShelterAddress = v.ShelterAddress
+		allPets = append(allPets, pt)
 	}
+	return allPets
 }
 
 //SearchPets :
 func (p *Pets) SearchPets(criteria *SearchCriteria) []*Pet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	var result []*Pet
 	for i := range p.allPets {
-		pet := &p.allPets[i]
-		match := true
-
-		// Kind check
-		if criteria.Kind != "" && pet.Variety != criteria.Kind {
-			match = false
-		}
-		// Sex check
-		if criteria.Sex != "" && pet.Sex != criteria.Sex {
-			match = false
-		}
-		// BodyType check
-		if criteria.BodyType != "" && pet.Type != criteria.BodyType {
-			match = false
-		}
-		// Age check
-		if criteria.Age != "" && pet.Age != criteria.Age {
-			match = false
+		if criteria.Matches(&p.allPets[i]) {
+			result = append(result, &p.allPets[i])
 		}
+	}
+	return photos.Rank(result)
+}
 
-		// Color check
-		if criteria.Color != "" && !strings.Contains(pet.HairType, criteria.Color) {
-			match = false
-		}
+// All returns every listed pet.
+func (p *Pets) All() []*Pet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
-		if match {
-			result = append(result, pet)
+	all := make([]*Pet, len(p.allPets))
+	for i := range p.allPets {
+		all[i] = &p.allPets[i]
+	}
+	return all
+}
+
+// Matches reports whether pet meets every criterion that is set. Criteria are
+// in the words users and Gemini use, such as 母 or 小型, and are turned into
+// the open data's codes before comparing.
+func (c *SearchCriteria) Matches(pet *Pet) bool {
+	switch {
+	case c.Kind != "" && pet.Variety != kind(c.Kind):
+		return false
+	case c.Sex != "" && pet.Sex != code(sexLabels, c.Sex):
+		return false
+	case c.BodyType != "" && pet.Type != code(bodyTypeLabels, c.BodyType):
+		return false
+	case c.Age != "" && pet.Age != code(ageLabels, c.Age):
+		return false
+	case c.Color != "" && !strings.Contains(pet.HairType, c.Color):
+		return false
+	case c.Pattern != "" && !strings.Contains(pet.HairType, c.Pattern):
+		return false
+	case c.Location != "" && !strings.Contains(normalizePlace(pet.Resettlement), normalizePlace(c.Location)):
+		return false
+	}
+	return true
+}
+
+// normalizePlace lets "台北" match shelters named "臺北市…".
+func normalizePlace(s string) string {
+	s = strings.ReplaceAll(s, "台", "臺")
+	return strings.TrimRight(s, "市縣")
+}
+
+// CountAtShelter returns how many listed pets are at the given shelter.
+func (p *Pets) CountAtShelter(resettlement string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	n := 0
+	for i := range p.allPets {
+		if p.allPets[i].Resettlement == resettlement {
+			n++
 		}
 	}
-	return result
+	return n
 }
 
 //GetPet :
 func (p *Pets) GetPet(id int) *Pet {
-	if len(p.allPets) == 0 {
+	if p.GetPetsCount() == 0 {
 		p.getPets()
 	}
 
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	for _, pet := range p.allPets {
 		if pet.ID == id {
 			return &pet
@@ -182,3 +281,54 @@ func (p *Pets) GetPet(id int) *Pet {
 	}
 	return nil
 }
+
+// PetChange is a pet whose record differs between two catalogue loads.
+type PetChange struct {
+	Old Pet
+	New Pet
+}
+
+// ShelterChanged reports whether the pet moved to another shelter.
+func (c PetChange) ShelterChanged() bool {
+	return c.Old.Resettlement != c.New.Resettlement
+}
+
+// StatusChanged reports whether the pet's adoption status changed.
+func (c PetChange) StatusChanged() bool {
+	return c.Old.Status != c.New.Status
+}
+
+// CatalogueDiff describes the difference between two catalogue loads.
+type CatalogueDiff struct {
+	Added   []Pet
+	Removed []Pet
+	Changed []PetChange
+}
+
+// diffPets compares two catalogue loads by animal ID. A pet counts as changed
+// when its update time, status or shelter differs.
+func diffPets(old, fresh []Pet) *CatalogueDiff {
+	diff := &CatalogueDiff{}
+	oldByID := make(map[int]Pet, len(old))
+	for _, pet := range old {
+		oldByID[pet.ID] = pet
+	}
+
+	for _, pet := range fresh {
+		prev, ok := oldByID[pet.ID]
+		if !ok {
+			diff.Added = append(diff.Added, pet)
+			continue
+		}
+		delete(oldByID, pet.ID)
+		if prev.Updated != pet.Updated || prev.Status != pet.Status || prev.Resettlement != pet.Resettlement {
+			diff.Changed = append(diff.Changed, PetChange{Old: prev, New: pet})
+		}
+	}
+	for _, pet := range old {
+		if _, ok := oldByID[pet.ID]; ok {
+			diff.Removed = append(diff.Removed, pet)
+		}
+	}
+	return diff
+}
@@ -180,3 +180,35 @@ func TestSearchCats(t *testing.T) {
 		}
 	}
 }
+
+func TestDiffPets(t *testing.T) {
+	old := []Pet{
+		{ID: 1, Status: "OPEN", Updated: "2024/01/01"},
+		{ID: 2, Status: "OPEN", Updated: "2024/01/01"},
+		{ID: 3, Status: "OPEN", Updated: "2024/01/01", Resettlement: "A"},
+	}
+	fresh := []Pet{
+		{ID: 2, Status: "OPEN", Updated: "2024/01/01", ImageName: "proxied"},
+		{ID: 3, Status: "OPEN", Updated: "2024/01/02", Resettlement: "B"},
+		{ID: 4, Status: "OPEN"},
+	}
+
+	diff := diffPets(old, fresh)
+	if len(diff.Added) != 1 || diff.Added[0].ID != 4 {
+		t.Errorf("Expected pet 4 to be added, got %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].ID != 1 {
+		t.Errorf("Expected pet 1 to be removed, got %+v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].New.ID != 3 || !diff.Changed[0].ShelterChanged() {
+		t.Errorf("Expected pet 3 to have moved shelter, got %+v", diff.Changed)
+	}
+}
+
+func TestGetNextDogWithoutDogs(t *testing.T) {
+	p := new(Pets)
+	p.LoadPets(TaiwanPets{{AnimalID: 1, AnimalKind: "貓"}})
+	if pet := p.GetNextDog(); pet != nil {
+		t.Errorf("Expected no dog, got %+v", pet)
+	}
+}
@@ -0,0 +1,145 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// mapSearchURL opens a map search for an address.
+	mapSearchURL = "https://www.google.com/maps/search/?api=1&query="
+	// sourcePageURL is the pet's page on the national shelter system, where
+	// the open data comes from.
+	sourcePageURL = "https://asms.moa.gov.tw/Amlapp/App/AnnounceList.aspx?Id=%d&AcceptNum=%s&PageType=Adopt"
+)
+
+// phonePattern finds the first phone number in a shelter's contact field,
+// which sometimes lists several.
+var phonePattern = regexp.MustCompile(`\+?[0-9][0-9-]{5,}[0-9]`)
+
+// PetView is what a card shows for a pet. It is a copy made from the
+// catalogue record, so presentation code never holds, let alone changes, the
+// Pet stored in PetDB.
+type PetView struct {
+	ID       int
+	Name     string
+	Kind     string
+	Sex      string
+	BodyType string
+	Color    string
+	Age      string
+	Shelter  string
+	Phone    string
+	// ImageURL is the photo to show, already resolved to the proxy or the
+	// placeholder.
+	ImageURL string
+	// Profile is the generated introduction, or "" if there is none yet.
+	Profile string
+
+	// Details for the full record.
+	AcceptNum      string
+	Status         string
+	Sterilized     string
+	Vaccinated     string
+	FoundPlace     string
+	IntakeDate     string
+	OpenDate       string
+	Remark         string
+	ShelterName    string
+	ShelterAddress string
+
+	// PageURL is the pet's shareable page, "" without PUBLIC_URL.
+	PageURL string
+
+	// Links for the shelter actions, "" when the record lacks the data.
+	PhoneURI  string
+	MapURL    string
+	SourceURL string
+}
+
+var (
+	sexLabels      = map[string]string{"M": "公", "F": "母", "N": "不詳"}
+	bodyTypeLabels = map[string]string{"SMALL": "小型", "MEDIUM": "中型", "BIG": "大型"}
+	ageLabels      = map[string]string{"CHILD": "幼年", "ADULT": "成年"}
+)
+
+// newPetView makes the view of pet shown with the given photo and profile.
+// The open data's codes, such as M or SMALL, are shown as words.
+func newPetView(pet *Pet, imageURL, profile string) PetView {
+	return PetView{
+		ID:       pet.ID,
+		Name:     pet.Name,
+		Kind:     pet.Variety,
+		Sex:      label(sexLabels, pet.Sex),
+		BodyType: label(bodyTypeLabels, pet.Type),
+		Color:    pet.HairType,
+		Age:      label(ageLabels, pet.Age),
+		Shelter:  pet.Resettlement,
+		Phone:    pet.Phone,
+		ImageURL: imageURL,
+		Profile:  profile,
+
+		AcceptNum:      pet.AcceptNum,
+		Status:         pet.StatusText(),
+		Sterilized:     pet.SterilizationText(),
+		Vaccinated:     pet.BacterinText(),
+		FoundPlace:     pet.FoundPlace,
+		IntakeDate:     pet.IntakeDate,
+		OpenDate:       pet.OpenDate,
+		Remark:         pet.Note,
+		ShelterName:    pet.ShelterName,
+		ShelterAddress: pet.ShelterAddress,
+
+		PageURL: petPageURL(pet.ID),
+
+		PhoneURI:  phoneURI(pet.Phone),
+		MapURL:    mapURL(pet.ShelterAddress),
+		SourceURL: sourceURL(pet),
+	}
+}
+
+// phoneURI returns a tel: link to the first number in phone.
+func phoneURI(phone string) string {
+	number := phonePattern.FindString(phone)
+	if number == "" {
+		return ""
+	}
+	return "tel:" + strings.ReplaceAll(number, "-", "")
+}
+
+func mapURL(address string) string {
+	if address == "" {
+		return ""
+	}
+	return mapSearchURL + url.QueryEscape(address)
+}
+
+func sourceURL(pet *Pet) string {
+	if pet.AcceptNum == "" {
+		return ""
+	}
+	return fmt.Sprintf(sourcePageURL, pet.ID, url.QueryEscape(pet.AcceptNum))
+}
+
+// label returns the word for an open data code, or the value itself if it is
+// not a known code.
+func label(labels map[string]string, value string) string {
+	if l, ok := labels[value]; ok {
+		return l
+	}
+	return value
+}
@@ -0,0 +1,80 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewPetView(t *testing.T) {
+	pet := &Pet{ID: 7, Name: "小黑", Variety: "狗", Sex: "F", Type: "BIG", Age: "CHILD", HairType: "黑色", Resettlement: "臺北市動物之家", Phone: "02-1234"}
+	view := newPetView(pet, "https://bot.example/img/7", "活潑的小狗")
+
+	want := PetView{ID: 7, Name: "小黑", Kind: "狗", Sex: "母", BodyType: "大型", Color: "黑色", Age: "幼年", Shelter: "臺北市動物之家", Phone: "02-1234", ImageURL: "https://bot.example/img/7", Profile: "活潑的小狗",
+		Status: "其他", Sterilized: "不詳", Vaccinated: "不詳", PhoneURI: "tel:021234"}
+	if view != want {
+		t.Errorf("got %+v, want %+v", view, want)
+	}
+	if got := newPetView(&Pet{Sex: "公"}, "", "").Sex; got != "公" {
+		t.Errorf("Expected values that are not codes to be kept, got %q", got)
+	}
+}
+
+func TestShelterLinks(t *testing.T) {
+	for _, tt := range []struct {
+		phone, want string
+	}{
+		{"02-87913254", "tel:0287913254"},
+		{"(02)8791-3254", "tel:87913254"},
+		{"049-2225440、049-2225441", "tel:0492225440"},
+		{"+886-2-87913254", "tel:+886287913254"},
+		{"請洽官網", ""},
+		{"", ""},
+	} {
+		if got := phoneURI(tt.phone); got != tt.want {
+			t.Errorf("phoneURI(%q) = %q, want %q", tt.phone, got, tt.want)
+		}
+	}
+
+	view := newPetView(&Pet{ID: 9, AcceptNum: "A 1", ShelterAddress: "臺中市南屯區"}, "", "")
+	if view.MapURL != "https://www.google.com/maps/search/?api=1&query=%E8%87%BA%E4%B8%AD%E5%B8%82%E5%8D%97%E5%B1%AF%E5%8D%80" {
+		t.Errorf("Unexpected map URL %s", view.MapURL)
+	}
+	if view.SourceURL != "https://asms.moa.gov.tw/Amlapp/App/AnnounceList.aspx?Id=9&AcceptNum=A+1&PageType=Adopt" {
+		t.Errorf("Unexpected source URL %s", view.SourceURL)
+	}
+	if empty := newPetView(&Pet{ID: 9}, "", ""); empty.PhoneURI != "" || empty.MapURL != "" || empty.SourceURL != "" {
+		t.Errorf("Expected no links without shelter data, got %+v", empty)
+	}
+}
+
+func TestRenderingLeavesPetUnchanged(t *testing.T) {
+	saved := PetDB
+	defer func() { PetDB = saved }()
+	PetDB = new(Pets)
+	PetDB.LoadPets(TaiwanPets{
+		{AnimalID: 1, AnimalKind: "狗", AnimalSex: "M", AnimalBodytype: "SMALL", AlbumFile: "http://shelter.example/1.jpg"},
+	})
+
+	// All points into the catalogue, unlike GetPet, which returns a copy.
+	pet := PetDB.All()[0]
+	before := *pet
+	ctx := context.Background()
+	newPetFlexMessage(ctx, pet, "介紹", createFavoriteButton(pet))
+	petBubbles(ctx, []*Pet{pet}, createFavoriteButton)
+
+	if after := *PetDB.All()[0]; after != before {
+		t.Errorf("Rendering changed the catalogue record: got %+v, want %+v", after, before)
+	}
+}
@@ -0,0 +1,230 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// brokenPhotoRetry is how long a photo that failed to load is treated as
+	// broken before it is tried again.
+	brokenPhotoRetry = time.Hour
+	// photoRecheckInterval is how long a working photo is trusted before the
+	// validator checks it again.
+	photoRecheckInterval = 24 * time.Hour
+	// photoCheckWorkers is how many photos the validator checks at once.
+	photoCheckWorkers = 8
+)
+
+// photoQuality ranks pets for search: a checked photo beats an unchecked
+// one, which beats none at all.
+type photoQuality int
+
+const (
+	photoMissing photoQuality = iota
+	photoUnchecked
+	photoWorking
+)
+
+// photoCheck is the outcome of loading a pet's photo.
+type photoCheck struct {
+	src     string
+	ok      bool
+	checked time.Time
+}
+
+// photoRegistry records which animals have working photos, from both the
+// validator and the image proxy. Results are keyed by animal ID and dropped
+// when the shelter changes the photo's address.
+type photoRegistry struct {
+	mu     sync.Mutex
+	checks map[int]photoCheck
+	// hideMissing leaves pets without a working photo out of search results.
+	hideMissing bool
+}
+
+var photos = newPhotoRegistry()
+
+func newPhotoRegistry() *photoRegistry {
+	return &photoRegistry{checks: make(map[int]photoCheck)}
+}
+
+// initializePhotoChecks reads HIDE_PETS_WITHOUT_PHOTOS.
+func initializePhotoChecks() {
+	photos.hideMissing = os.Getenv("HIDE_PETS_WITHOUT_PHOTOS") == "true"
+	if photos.hideMissing {
+		log.Println("Pets without a working photo are hidden from search results.")
+	}
+}
+
+// Record stores whether pet's photo loaded.
+func (r *photoRegistry) Record(pet *Pet, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[pet.ID] = photoCheck{src: pet.ImageName, ok: ok, checked: time.Now()}
+}
+
+// Quality returns how good pet's photo is known to be.
+func (r *photoRegistry) Quality(pet *Pet) photoQuality {
+	if pet.ImageName == "" {
+		return photoMissing
+	}
+	r.mu.Lock()
+	c, known := r.checks[pet.ID]
+	r.mu.Unlock()
+	switch {
+	case !known || c.src != pet.ImageName:
+		return photoUnchecked
+	case c.ok:
+		return photoWorking
+	case time.Since(c.checked) < brokenPhotoRetry:
+		return photoMissing
+	default:
+		return photoUnchecked
+	}
+}
+
+// needsCheck reports whether the validator should look at pet's photo.
+func (r *photoRegistry) needsCheck(pet *Pet) bool {
+	if pet.ImageName == "" {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, known := r.checks[pet.ID]
+	switch {
+	case !known || c.src != pet.ImageName:
+		return true
+	case c.ok:
+		return time.Since(c.checked) >= photoRecheckInterval
+	default:
+		return time.Since(c.checked) >= brokenPhotoRetry
+	}
+}
+
+// Rank orders pets with working photos first, keeping the order otherwise,
+// and drops those without one if hideMissing is set.
+func (r *photoRegistry) Rank(pets []*Pet) []*Pet {
+	quality := make(map[int]photoQuality, len(pets))
+	ranked := make([]*Pet, 0, len(pets))
+	for _, pet := range pets {
+		q := r.Quality(pet)
+		if r.hideMissing && q == photoMissing {
+			continue
+		}
+		quality[pet.ID] = q
+		ranked = append(ranked, pet)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return quality[ranked[i].ID] > quality[ranked[j].ID]
+	})
+	return ranked
+}
+
+// validatePhotos checks the photo of every listed pet that has not been
+// checked recently, a few at a time, until done or ctx is cancelled.
+func validatePhotos(ctx context.Context, r *photoRegistry, pets []*Pet) {
+	work := make(chan *Pet)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	checked, broken := 0, 0
+	for i := 0; i < photoCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pet := range work {
+				err := checkPhoto(ctx, pet.ImageName)
+				if ctx.Err() != nil {
+					return
+				}
+				r.Record(pet, err == nil)
+				mu.Lock()
+				checked++
+				if err != nil {
+					broken++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, pet := range pets {
+		if !r.needsCheck(pet) {
+			continue
+		}
+		select {
+		case work <- pet:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	log.Printf("Checked %d photos, %d broken", checked, broken)
+}
+
+// checkPhoto makes sure src is reachable and an image. It asks for the
+// headers only, falling back to the first bytes for servers that do not
+// support HEAD or do not send a content type.
+func checkPhoto(ctx context.Context, src string) error {
+	ctx, cancel := context.WithTimeout(ctx, imageFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, src, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=0-511")
+	resp, err = imageClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if err != nil {
+		return err
+	}
+	if contentType := http.DetectContentType(head); !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("%w: %s", errNotImage, contentType)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestValidatePhotos(t *testing.T) {
+	photo := testPNG(t)
+	shelter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(photo)
+		case "/nohead.png":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(photo)
+		case "/page.png":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>Not found</html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer shelter.Close()
+
+	pets := []*Pet{
+		{ID: 1, ImageName: shelter.URL + "/missing.png"},
+		{ID: 2, ImageName: shelter.URL + "/ok.png"},
+		{ID: 3},
+		{ID: 4, ImageName: shelter.URL + "/nohead.png"},
+		{ID: 5, ImageName: shelter.URL + "/page.png"},
+	}
+	r := newPhotoRegistry()
+	validatePhotos(context.Background(), r, pets)
+
+	want := []photoQuality{photoMissing, photoWorking, photoMissing, photoWorking, photoMissing}
+	for i, pet := range pets {
+		if got := r.Quality(pet); got != want[i] {
+			t.Errorf("Pet %d: got quality %d, want %d", pet.ID, got, want[i])
+		}
+		if r.needsCheck(pet) {
+			t.Errorf("Pet %d should not need another check yet", pet.ID)
+		}
+	}
+
+	// A new address is checked again.
+	moved := &Pet{ID: 2, ImageName: shelter.URL + "/missing.png"}
+	if r.Quality(moved) != photoUnchecked || !r.needsCheck(moved) {
+		t.Error("Expected a changed photo address to be unchecked")
+	}
+}
+
+func TestPhotoRank(t *testing.T) {
+	r := newPhotoRegistry()
+	none := &Pet{ID: 1}
+	broken := &Pet{ID: 2, ImageName: "http://shelter.example/2.jpg"}
+	unchecked := &Pet{ID: 3, ImageName: "http://shelter.example/3.jpg"}
+	working := &Pet{ID: 4, ImageName: "http://shelter.example/4.jpg"}
+	working2 := &Pet{ID: 5, ImageName: "http://shelter.example/5.jpg"}
+	r.Record(broken, false)
+	r.Record(working, true)
+	r.Record(working2, true)
+
+	ids := func(pets []*Pet) []int {
+		var ids []int
+		for _, p := range pets {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	pets := []*Pet{none, broken, working, unchecked, working2}
+	if got, want := ids(r.Rank(pets)), []int{4, 5, 3, 1, 2}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	r.hideMissing = true
+	if got, want := ids(r.Rank(pets)), []int{4, 5, 3}; !slices.Equal(got, want) {
+		t.Errorf("With hideMissing got %v, want %v", got, want)
+	}
+}
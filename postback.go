@@ -0,0 +1,102 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// postbackVersion is the current postback data format. Buttons already sent to
+// users keep working after an upgrade, so decoding accepts every older version.
+const postbackVersion = 1
+
+// PostbackAction names what a postback button does.
+type PostbackAction string
+
+const (
+	postbackFavorite       PostbackAction = "favorite"
+	postbackUnfavorite     PostbackAction = "unfavorite"
+	postbackMoreLikeThis   PostbackAction = "more"
+	postbackShelterInfo    PostbackAction = "shelter"
+	postbackDetails        PostbackAction = "details"
+	postbackShortlist      PostbackAction = "shortlist"
+	postbackVote           PostbackAction = "vote"
+	postbackClearFavorites PostbackAction = "clear_favorites"
+	postbackCancel         PostbackAction = "cancel"
+)
+
+// needsPet reports whether the action refers to a pet.
+func (a PostbackAction) needsPet() bool {
+	switch a {
+	case postbackFavorite, postbackUnfavorite, postbackMoreLikeThis, postbackShelterInfo, postbackDetails, postbackShortlist, postbackVote:
+		return true
+	}
+	return false
+}
+
+// Postback is the data carried by a postback button.
+type Postback struct {
+	Action PostbackAction
+	PetID  int
+}
+
+// Encode returns the postback data, e.g. "v=1&action=favorite&pet=123".
+func (p Postback) Encode() string {
+	v := url.Values{}
+	v.Set("v", strconv.Itoa(postbackVersion))
+	v.Set("action", string(p.Action))
+	if p.PetID != 0 {
+		v.Set("pet", strconv.Itoa(p.PetID))
+	}
+	return v.Encode()
+}
+
+// decodePostback parses postback data in the current format or the
+// unversioned "action=favorite&petID=123" format used before it.
+func decodePostback(data string) (Postback, error) {
+	params, err := url.ParseQuery(data)
+	if err != nil {
+		return Postback{}, fmt.Errorf("failed to parse postback data: %w", err)
+	}
+
+	version := 0
+	if v := params.Get("v"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil {
+			return Postback{}, fmt.Errorf("invalid postback version %q", v)
+		}
+	}
+	petKey := "pet"
+	switch version {
+	case 0:
+		petKey = "petID"
+	case postbackVersion:
+	default:
+		return Postback{}, fmt.Errorf("unsupported postback version %d", version)
+	}
+
+	p := Postback{Action: PostbackAction(params.Get("action"))}
+	if p.Action == "" {
+		return Postback{}, fmt.Errorf("postback data has no action: %q", data)
+	}
+	if id := params.Get(petKey); id != "" {
+		if p.PetID, err = strconv.Atoi(id); err != nil {
+			return Postback{}, fmt.Errorf("invalid pet ID %q", id)
+		}
+	}
+	if p.Action.needsPet() && p.PetID == 0 {
+		return Postback{}, fmt.Errorf("postback action %s needs a pet ID", p.Action)
+	}
+	return p, nil
+}
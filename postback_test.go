@@ -0,0 +1,66 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestPostbackRoundTrip(t *testing.T) {
+	for _, want := range []Postback{
+		{Action: postbackFavorite, PetID: 123},
+		{Action: postbackUnfavorite, PetID: 4},
+		{Action: postbackMoreLikeThis, PetID: 56},
+		{Action: postbackShelterInfo, PetID: 789},
+		{Action: postbackShortlist, PetID: 10},
+		{Action: postbackVote, PetID: 11},
+		{Action: postbackClearFavorites},
+		{Action: postbackCancel},
+	} {
+		data := want.Encode()
+		if len(data) > 300 {
+			t.Errorf("Postback data %q is over LINE's 300 character limit", data)
+		}
+		got, err := decodePostback(data)
+		if err != nil {
+			t.Errorf("Failed to decode %q: %v", data, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Decoded %q as %+v, want %+v", data, got, want)
+		}
+	}
+}
+
+func TestDecodeLegacyPostback(t *testing.T) {
+	got, err := decodePostback("action=unfavorite&petID=42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Postback{Action: postbackUnfavorite, PetID: 42}); got != want {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeInvalidPostback(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"v=2&action=favorite&pet=1",
+		"v=x&action=favorite&pet=1",
+		"v=1&action=favorite",
+		"v=1&action=favorite&pet=abc",
+		"action=unfavorite&pet=1", // Legacy data used petID
+	} {
+		if p, err := decodePostback(data); err == nil {
+			t.Errorf("Expected %q to be rejected, got %+v", data, p)
+		}
+	}
+}
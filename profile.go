@@ -0,0 +1,156 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// profileWarmTimeout bounds a background profile generation.
+const profileWarmTimeout = 30 * time.Second
+
+// ProfileGenerator writes a short adoption introduction for a pet.
+type ProfileGenerator interface {
+	GenerateProfile(ctx context.Context, pet *Pet) (string, error)
+}
+
+// petProfiles is nil when profile generation is disabled.
+var petProfiles *profileCache
+
+type geminiProfileGenerator struct {
+	model *geminiModel
+}
+
+// GenerateProfile asks Gemini for a warm Traditional Chinese introduction
+// based only on the pet's structured fields.
+func (g *geminiProfileGenerator) GenerateProfile(ctx context.Context, pet *Pet) (string, error) {
+	prompt := `
+You are a volunteer at an animal shelter in Taiwan writing adoption profiles.
+Write a short, warm introduction (at most 60 characters) in Traditional Chinese for the animal below.
+Only use the facts given; do not invent personality traits, health conditions or history.
+Return only the introduction text.
+
+` + petFacts(pet)
+
+	// Profiles are shown to everyone, so they only count toward the global quota.
+	resp, err := g.model.GenerateContent(contextWithUser(ctx, ""), genai.Text(prompt))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(responseText(resp)), nil
+}
+
+// petFacts lists the structured fields of a pet for use in prompts.
+func petFacts(pet *Pet) string {
+	return fmt.Sprintf("種類：%s\n性別：%s\n體型：%s\n毛色：%s\n年紀：%s\n收容所：%s\n備註：%s",
+		pet.Variety, pet.Sex, pet.Type, pet.HairType, pet.Age, pet.Resettlement, pet.Note)
+}
+
+type profileEntry struct {
+	fingerprint string
+	text        string
+}
+
+// profileCache keeps generated profiles per animal ID and regenerates them
+// when the animal's record changes.
+type profileCache struct {
+	gen ProfileGenerator
+
+	mu      sync.Mutex
+	entries map[int]profileEntry
+	pending map[int]bool
+}
+
+func newProfileCache(gen ProfileGenerator) *profileCache {
+	return &profileCache{
+		gen:     gen,
+		entries: make(map[int]profileEntry),
+		pending: make(map[int]bool),
+	}
+}
+
+// Profile returns the pet's profile, generating it if it is missing or stale.
+// It returns "" when profiles are disabled or generation fails.
+func (c *profileCache) Profile(ctx context.Context, pet *Pet) string {
+	if c == nil || pet == nil {
+		return ""
+	}
+	if text, ok := c.lookup(pet); ok {
+		return text
+	}
+	return c.generate(ctx, pet)
+}
+
+// Cached returns the pet's profile if it is already cached and fresh. Otherwise
+// it returns "" and generates the profile in the background for next time.
+func (c *profileCache) Cached(pet *Pet) string {
+	if c == nil || pet == nil {
+		return ""
+	}
+	if text, ok := c.lookup(pet); ok {
+		return text
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.pending[pet.ID] {
+		c.pending[pet.ID] = true
+		p := *pet
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), profileWarmTimeout)
+			defer cancel()
+			c.generate(ctx, &p)
+		}()
+	}
+	return ""
+}
+
+func (c *profileCache) lookup(pet *Pet) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[pet.ID]
+	if !ok || entry.fingerprint != profileFingerprint(pet) {
+		return "", false
+	}
+	return entry.text, true
+}
+
+func (c *profileCache) generate(ctx context.Context, pet *Pet) string {
+	text, err := c.gen.GenerateProfile(ctx, pet)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, pet.ID)
+	if err != nil {
+		log.Printf("Failed to generate profile for pet %d: %v", pet.ID, err)
+		return ""
+	}
+	c.entries[pet.ID] = profileEntry{fingerprint: profileFingerprint(pet), text: text}
+	return text
+}
+
+// profileFingerprint identifies the version of a pet record a profile was
+// generated from.
+func profileFingerprint(pet *Pet) string {
+	sum := sha1.Sum([]byte(petFacts(pet)))
+	return hex.EncodeToString(sum[:])
+}
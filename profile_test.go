@@ -0,0 +1,87 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeProfileGenerator struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (g *fakeProfileGenerator) GenerateProfile(ctx context.Context, pet *Pet) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls++
+	return "親人的" + pet.Variety, nil
+}
+
+func (g *fakeProfileGenerator) Calls() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+func TestProfileCache(t *testing.T) {
+	gen := &fakeProfileGenerator{}
+	cache := newProfileCache(gen)
+	pet := &Pet{ID: 1, Variety: "狗"}
+
+	if text := cache.Profile(context.Background(), pet); text != "親人的狗" {
+		t.Errorf("Unexpected profile %q", text)
+	}
+	cache.Profile(context.Background(), pet)
+	if gen.Calls() != 1 {
+		t.Errorf("Expected the profile to be cached, got %d calls", gen.Calls())
+	}
+
+	changed := &Pet{ID: 1, Variety: "貓"}
+	if text := cache.Profile(context.Background(), changed); text != "親人的貓" {
+		t.Errorf("Expected the profile to be regenerated after a change, got %q", text)
+	}
+	if gen.Calls() != 2 {
+		t.Errorf("Expected 2 calls, got %d", gen.Calls())
+	}
+}
+
+func TestProfileCacheWarmsInBackground(t *testing.T) {
+	gen := &fakeProfileGenerator{}
+	cache := newProfileCache(gen)
+	pet := &Pet{ID: 2, Variety: "貓"}
+
+	if text := cache.Cached(pet); text != "" {
+		t.Errorf("Expected no cached profile yet, got %q", text)
+	}
+	deadline := time.Now().Add(time.Second)
+	for cache.Cached(pet) == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if text := cache.Cached(pet); text != "親人的貓" {
+		t.Errorf("Expected the profile to be warmed, got %q", text)
+	}
+}
+
+func TestDisabledProfileCache(t *testing.T) {
+	var cache *profileCache
+	if text := cache.Profile(context.Background(), &Pet{ID: 1}); text != "" {
+		t.Errorf("Expected no profile when disabled, got %q", text)
+	}
+	if text := cache.Cached(&Pet{ID: 1}); text != "" {
+		t.Errorf("Expected no profile when disabled, got %q", text)
+	}
+}
@@ -0,0 +1,173 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// noAnswer is the reply when the data does not cover a question.
+const noAnswer = "抱歉，資料中沒有這項資訊，建議直接聯絡收容所確認。"
+
+//go:embed knowledge/adoption_rules.json
+var defaultKnowledge []byte
+
+// QuestionAnswerer answers an adoption question using only the given facts.
+type QuestionAnswerer interface {
+	Answer(ctx context.Context, question, facts string) (string, error)
+}
+
+// questionAnswerer is nil when no Q&A backend is configured.
+var questionAnswerer QuestionAnswerer
+
+// adoptionKnowledge holds the adoption rules used to ground answers, loaded by
+// initializeKnowledge.
+var adoptionKnowledge *KnowledgeBase
+
+// KnowledgeBase is the adoption rules file. Default rules apply to every
+// shelter; Shelters adds rules keyed by shelter name.
+type KnowledgeBase struct {
+	Default  []string            `json:"default"`
+	Shelters map[string][]string `json:"shelters"`
+}
+
+// initializeKnowledge loads ADOPTION_KNOWLEDGE_FILE, falling back to the
+// rules built into the binary.
+func initializeKnowledge() error {
+	data := defaultKnowledge
+	if path := os.Getenv("ADOPTION_KNOWLEDGE_FILE"); path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return fmt.Errorf("error reading adoption knowledge file: %w", err)
+		}
+	}
+	kb, err := parseKnowledge(data)
+	if err != nil {
+		return fmt.Errorf("error parsing adoption knowledge: %w", err)
+	}
+	adoptionKnowledge = kb
+	return nil
+}
+
+func parseKnowledge(data []byte) (*KnowledgeBase, error) {
+	var kb KnowledgeBase
+	if err := json.Unmarshal(data, &kb); err != nil {
+		return nil, err
+	}
+	return &kb, nil
+}
+
+// Rules returns the rules that apply to the shelter a pet is in. A nil pet
+// gets the default rules only.
+func (kb *KnowledgeBase) Rules(pet *Pet) []string {
+	if kb == nil {
+		return nil
+	}
+	rules := append([]string{}, kb.Default...)
+	if pet == nil {
+		return rules
+	}
+	for shelter, extra := range kb.Shelters {
+		if strings.HasPrefix(pet.Resettlement, shelter) {
+			rules = append(rules, extra...)
+		}
+	}
+	return rules
+}
+
+type geminiAnswerer struct {
+	model *geminiModel
+}
+
+// Answer asks Gemini to answer strictly from the given facts.
+func (a *geminiAnswerer) Answer(ctx context.Context, question, facts string) (string, error) {
+	prompt := `
+You are a pet adoption assistant in Taiwan. Answer the user's question in Traditional Chinese, briefly and kindly.
+Use ONLY the facts below. Do not guess or add information that is not in the facts.
+If the facts do not answer the question, reply exactly: "` + noAnswer + `"
+
+Facts:
+` + facts + `
+
+Question: ` + question
+
+	resp, err := a.model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", err
+	}
+	answer := strings.TrimSpace(responseText(resp))
+	if answer == "" {
+		return noAnswer, nil
+	}
+	return answer, nil
+}
+
+var (
+	questionMarkers = []string{"嗎", "什麼", "甚麼", "怎麼", "如何", "為什麼", "多少", "哪裡", "是否", "?", "？"}
+	questionTopics  = []string{"條件", "結紮", "絕育", "疫苗", "晶片", "費用", "流程", "手續", "規定", "證件", "時間"}
+	petReferences   = []string{"這隻", "那隻", "牠", "它"}
+	searchWords     = []string{"貓", "狗", "犬", "喵", "小型", "中型", "大型", "幼年", "成年", "公的", "母的"}
+)
+
+// isQuestion reports whether text asks about adoption rather than searching,
+// so "有小型犬嗎" stays a search while "這隻有結紮嗎" is a question. Asking
+// about adoption rules or the current pet is a question even when the text
+// names a kind of pet.
+func isQuestion(text string) bool {
+	if !containsAny(text, questionMarkers) {
+		return false
+	}
+	if containsAny(text, questionTopics) || containsAny(text, petReferences) {
+		return true
+	}
+	return !containsAny(text, searchWords)
+}
+
+func containsAny(text string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(text, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// questionFacts collects the pet record and the applicable adoption rules.
+func questionFacts(pet *Pet, kb *KnowledgeBase) string {
+	var b strings.Builder
+	if pet != nil {
+		fmt.Fprintf(&b, "目前查看的動物：\n名字：%s\n%s\n絕育：%s\n聯絡電話：%s\n\n", pet.Name, petFacts(pet), pet.SterilizationText(), pet.Phone)
+	}
+	b.WriteString("認養規定：\n")
+	for _, rule := range kb.Rules(pet) {
+		b.WriteString("- " + rule + "\n")
+	}
+	return b.String()
+}
+
+// answerQuestion answers a question about the given pet, or about adoption in
+// general if pet is nil. Without a Q&A backend the relevant facts are returned.
+func answerQuestion(ctx context.Context, answerer QuestionAnswerer, pet *Pet, question string) (string, error) {
+	facts := questionFacts(pet, adoptionKnowledge)
+	if answerer == nil {
+		return "以下是目前的相關資料，供您參考：\n\n" + facts, nil
+	}
+	return answerer.Answer(ctx, question, facts)
+}
@@ -0,0 +1,92 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type fakeAnswerer struct {
+	facts string
+}
+
+func (a *fakeAnswerer) Answer(ctx context.Context, question, facts string) (string, error) {
+	a.facts = facts
+	return noAnswer, nil
+}
+
+func TestIsQuestion(t *testing.T) {
+	tests := []struct {
+		text     string
+		question bool
+	}{
+		{"領養需要什麼條件", true},
+		{"這隻有結紮嗎", true},
+		{"認養流程是什麼？", true},
+		{"收容所在哪裡", true},
+		{"怎麼領養", true},
+		{"這隻狗幾歲？", true},
+		{"認養狗需要什麼證件", true},
+		{"有貓嗎", false},
+		{"有小型犬嗎", false},
+		{"有狗狗需要認養嗎", false},
+		{"有幼年的母貓嗎", false},
+		{"我想找一隻小隻的母狗", false},
+		{"狗", false},
+		{"收藏", false},
+	}
+	for _, tt := range tests {
+		if got := isQuestion(tt.text); got != tt.question {
+			t.Errorf("isQuestion(%q) = %v, want %v", tt.text, got, tt.question)
+		}
+	}
+}
+
+func TestKnowledgeBaseRules(t *testing.T) {
+	kb, err := parseKnowledge([]byte(`{"default": ["須年滿 20 歲"], "shelters": {"臺北市動物之家": ["週一休館"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := kb.Rules(&Pet{Resettlement: "臺北市動物之家(臺北市內湖區)"})
+	if len(rules) != 2 {
+		t.Errorf("Expected default and shelter rules, got %v", rules)
+	}
+	if rules := kb.Rules(&Pet{Resettlement: "新北市板橋區公立動物之家(新北市板橋區)"}); len(rules) != 1 {
+		t.Errorf("Expected only default rules, got %v", rules)
+	}
+	if rules := kb.Rules(nil); len(rules) != 1 {
+		t.Errorf("Expected only default rules, got %v", rules)
+	}
+}
+
+func TestAnswerQuestionIsGrounded(t *testing.T) {
+	pet := &Pet{ID: 1, Name: "A123", Variety: "狗", IsSterilization: "T"}
+	answerer := &fakeAnswerer{}
+	if _, err := answerQuestion(context.Background(), answerer, pet, "這隻有結紮嗎"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(answerer.facts, "已絕育") || !strings.Contains(answerer.facts, "A123") {
+		t.Errorf("Expected the pet record in the facts, got %q", answerer.facts)
+	}
+
+	answer, err := answerQuestion(context.Background(), nil, nil, "領養需要什麼條件")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(answer, adoptionKnowledge.Default[0]) {
+		t.Errorf("Expected the adoption rules without a backend, got %q", answer)
+	}
+}
@@ -0,0 +1,129 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	// maxQuickReplies is the most quick reply buttons LINE shows.
+	maxQuickReplies = 13
+	// maxQuickReplyLabel is the longest quick reply label LINE accepts.
+	maxQuickReplyLabel = 20
+	// maxRefinements limits the "不限…" suggestions so the common ones fit.
+	maxRefinements = 3
+)
+
+// quickReplyBuilder collects quick reply buttons, dropping any beyond what
+// LINE accepts.
+type quickReplyBuilder struct {
+	buttons []*linebot.QuickReplyButton
+}
+
+func newQuickReplies() *quickReplyBuilder {
+	return &quickReplyBuilder{}
+}
+
+func (b *quickReplyBuilder) add(action linebot.QuickReplyAction) *quickReplyBuilder {
+	if len(b.buttons) < maxQuickReplies {
+		b.buttons = append(b.buttons, linebot.NewQuickReplyButton("", action))
+	}
+	return b
+}
+
+// Message adds a button that sends text as the user.
+func (b *quickReplyBuilder) Message(label, text string) *quickReplyBuilder {
+	return b.add(linebot.NewMessageAction(truncateLabel(label), text))
+}
+
+// Postback adds a button that sends p without posting text to the chat.
+func (b *quickReplyBuilder) Postback(label string, p Postback) *quickReplyBuilder {
+	label = truncateLabel(label)
+	return b.add(linebot.NewPostbackAction(label, p.Encode(), "", label, "", ""))
+}
+
+// Location adds a button that asks the user to share a location.
+func (b *quickReplyBuilder) Location(label string) *quickReplyBuilder {
+	return b.add(linebot.NewLocationAction(truncateLabel(label)))
+}
+
+// Build returns the quick replies, or nil if there are none.
+func (b *quickReplyBuilder) Build() *linebot.QuickReplyItems {
+	if len(b.buttons) == 0 {
+		return nil
+	}
+	return linebot.NewQuickReplyItems(b.buttons...)
+}
+
+func truncateLabel(label string) string {
+	if r := []rune(label); len(r) > maxQuickReplyLabel {
+		return string(r[:maxQuickReplyLabel])
+	}
+	return label
+}
+
+// petSuggestions are the quick replies shown after a single pet: favourite it,
+// see more of the same kind nearby or at random, then the search suggestions.
+func petSuggestions(pet *Pet, criteria *SearchCriteria) *linebot.QuickReplyItems {
+	b := newQuickReplies()
+	if pet != nil {
+		b.Postback("收藏這隻", Postback{Action: postbackFavorite, PetID: pet.ID})
+		if pet.Variety != "" {
+			b.Location("附近的" + pet.Variety)
+		}
+	}
+	b.Message("下一隻狗", "狗").Message("下一隻貓", "貓")
+	return addSearchSuggestions(b, criteria).Build()
+}
+
+// searchSuggestions are the quick replies shown after search results.
+func searchSuggestions(criteria *SearchCriteria) *linebot.QuickReplyItems {
+	b := newQuickReplies()
+	if criteria.IsEmpty() || criteria.Location == "" {
+		b.Location("找附近的")
+	}
+	return addSearchSuggestions(b, criteria).Message("下一隻狗", "狗").Message("下一隻貓", "貓").Build()
+}
+
+// addSearchSuggestions offers to loosen the criteria that are set, which
+// parseLocalRefinements understands without calling Gemini, and to subscribe
+// to or reset the search.
+func addSearchSuggestions(b *quickReplyBuilder, criteria *SearchCriteria) *quickReplyBuilder {
+	if criteria.IsEmpty() {
+		return b
+	}
+	refinements := []struct {
+		value, phrase string
+	}{
+		{criteria.Sex, "不限性別"},
+		{criteria.BodyType, "不限體型"},
+		{criteria.Age, "不限年紀"},
+		{criteria.Color, "不限毛色"},
+		{criteria.Pattern, "不限花紋"},
+		{criteria.Location, "不限地區"},
+	}
+	n := 0
+	for _, r := range refinements {
+		if r.value != "" && n < maxRefinements {
+			b.Message(r.phrase, r.phrase)
+			n++
+		}
+	}
+	if criteria.Kind == "狗" {
+		b.Message("換成貓", "換成貓")
+	} else if criteria.Kind == "貓" {
+		b.Message("換成狗", "換成狗")
+	}
+	return b.Message("訂閱這個搜尋", subscribeCommand).Message(resetCommand, resetCommand)
+}
@@ -0,0 +1,98 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// quickReplyTexts describes each button as its label, plus the text or
+// postback it sends.
+func quickReplyTexts(items *linebot.QuickReplyItems) []string {
+	if items == nil {
+		return nil
+	}
+	var texts []string
+	for _, item := range items.Items {
+		switch a := item.Action.(type) {
+		case *linebot.MessageAction:
+			texts = append(texts, a.Label+"="+a.Text)
+		case *linebot.PostbackAction:
+			texts = append(texts, a.Label+"="+a.Data)
+		case *linebot.LocationAction:
+			texts = append(texts, a.Label+"=location")
+		}
+	}
+	return texts
+}
+
+func TestPetSuggestions(t *testing.T) {
+	pet := &Pet{ID: 7, Variety: "狗"}
+	got := strings.Join(quickReplyTexts(petSuggestions(pet, nil)), "|")
+	want := "收藏這隻=" + (Postback{Action: postbackFavorite, PetID: 7}).Encode() + "|附近的狗=location|下一隻狗=狗|下一隻貓=貓"
+	if got != want {
+		t.Errorf("Got %s, want %s", got, want)
+	}
+}
+
+func TestSearchSuggestions(t *testing.T) {
+	criteria := &SearchCriteria{Kind: "狗", Sex: "母", BodyType: "小型", Age: "幼年", Color: "白", Location: "臺北市"}
+	texts := quickReplyTexts(searchSuggestions(criteria))
+	got := strings.Join(texts, "|")
+	for _, want := range []string{"不限性別=不限性別", "不限體型=不限體型", "不限年紀=不限年紀", "換成貓=換成貓", "訂閱這個搜尋=訂閱", "重新搜尋=重新搜尋"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %s in %s", want, got)
+		}
+	}
+	if strings.Contains(got, "不限毛色") {
+		t.Errorf("Expected at most %d refinements, got %s", maxRefinements, got)
+	}
+	if strings.Contains(got, "location") {
+		t.Errorf("Expected no location button when a location is set, got %s", got)
+	}
+
+	if texts := quickReplyTexts(searchSuggestions(nil)); len(texts) != 3 || texts[0] != "找附近的=location" {
+		t.Errorf("Unexpected suggestions without criteria: %v", texts)
+	}
+}
+
+func TestNextPetSuggestionsAreCommands(t *testing.T) {
+	// Sent to the AI search, 「下一隻狗」 would replace the user's criteria.
+	for _, items := range []*linebot.QuickReplyItems{petSuggestions(&Pet{ID: 1}, nil), searchSuggestions(&SearchCriteria{Kind: "貓"})} {
+		for _, item := range items.Items {
+			if a, ok := item.Action.(*linebot.MessageAction); ok && strings.HasPrefix(a.Label, "下一隻") && !menuCommands[a.Text] {
+				t.Errorf("%s sends %q, which is not an exact command", a.Label, a.Text)
+			}
+		}
+	}
+}
+
+func TestQuickReplyBuilderLimits(t *testing.T) {
+	b := newQuickReplies()
+	if b.Build() != nil {
+		t.Error("Expected no quick replies from an empty builder")
+	}
+	for i := 0; i < 20; i++ {
+		b.Message("這是一個非常非常非常非常非常長的按鈕標籤", "x")
+	}
+	items := b.Build()
+	if len(items.Items) != maxQuickReplies {
+		t.Errorf("Expected %d buttons, got %d", maxQuickReplies, len(items.Items))
+	}
+	if label := items.Items[0].Action.(*linebot.MessageAction).Label; len([]rune(label)) > maxQuickReplyLabel {
+		t.Errorf("Expected the label to be truncated, got %q", label)
+	}
+}
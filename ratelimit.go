@@ -0,0 +1,81 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows up to limit calls per key in each fixed time window.
+// A nil limiter or a limit of zero allows everything.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	counts  map[string]*windowCount
+	sweptAt time.Time
+}
+
+type windowCount struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		counts: make(map[string]*windowCount),
+	}
+}
+
+// Allow records a call for key and reports whether it is within the limit.
+func (l *rateLimiter) Allow(key string) bool {
+	if l == nil || l.limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	wc, ok := l.counts[key]
+	if !ok || now.Sub(wc.start) >= l.window {
+		l.sweep(now)
+		wc = &windowCount{start: now}
+		l.counts[key] = wc
+	}
+	if wc.count >= l.limit {
+		return false
+	}
+	wc.count++
+	return true
+}
+
+// sweep drops the keys whose window has ended, at most once per window.
+// l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < l.window {
+		return
+	}
+	l.sweptAt = now
+	for key, wc := range l.counts {
+		if now.Sub(wc.start) >= l.window {
+			delete(l.counts, key)
+		}
+	}
+}
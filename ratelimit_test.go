@@ -0,0 +1,95 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("u1") || !l.Allow("u1") {
+		t.Fatal("Expected the first two calls to be allowed")
+	}
+	if l.Allow("u1") {
+		t.Error("Expected the third call to be rejected")
+	}
+	if !l.Allow("u2") {
+		t.Error("Expected quotas to be per key")
+	}
+
+	now = now.Add(time.Minute)
+	if !l.Allow("u1") {
+		t.Error("Expected the quota to reset in the next window")
+	}
+}
+
+func TestUnlimitedRateLimiter(t *testing.T) {
+	var nilLimiter *rateLimiter
+	unlimited := newRateLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if !nilLimiter.Allow("u1") || !unlimited.Allow("u1") {
+			t.Fatal("Expected unlimited limiters to allow every call")
+		}
+	}
+}
+
+func TestGeminiQuotaOrder(t *testing.T) {
+	m := &geminiModel{
+		userQuota:   newRateLimiter(2, time.Minute),
+		globalQuota: newRateLimiter(1, time.Minute),
+	}
+	ctx := contextWithUser(context.Background(), "u1")
+
+	if !m.allowCall(ctx) {
+		t.Fatal("Expected the first call to be allowed")
+	}
+	if m.allowCall(ctx) {
+		t.Fatal("Expected the call over the global quota to be rejected")
+	}
+
+	m.globalQuota = newRateLimiter(1, time.Minute)
+	if !m.allowCall(ctx) {
+		t.Error("Expected a call rejected by the global quota not to count toward the user's")
+	}
+}
+
+func TestLoadGeminiConfig(t *testing.T) {
+	t.Setenv("GEMINI_MODEL", "gemini-2.0-flash")
+	t.Setenv("GEMINI_TEMPERATURE", "0.2")
+	t.Setenv("GEMINI_TIMEOUT", "5s")
+	t.Setenv("GEMINI_USER_RPM", "3")
+	t.Setenv("GEMINI_GLOBAL_RPM", "bad")
+
+	conf := loadGeminiConfig()
+	if conf.Model != "gemini-2.0-flash" {
+		t.Errorf("Unexpected model %q", conf.Model)
+	}
+	if conf.Temperature == nil || *conf.Temperature != 0.2 {
+		t.Errorf("Unexpected temperature %v", conf.Temperature)
+	}
+	if conf.Timeout != 5*time.Second {
+		t.Errorf("Unexpected timeout %s", conf.Timeout)
+	}
+	if conf.UserRPM != 3 {
+		t.Errorf("Unexpected user quota %d", conf.UserRPM)
+	}
+	if conf.GlobalRPM != 0 {
+		t.Errorf("Expected the default global quota for an invalid value, got %d", conf.GlobalRPM)
+	}
+}
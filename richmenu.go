@@ -0,0 +1,238 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+// RichMenuConfig is the declarative rich menu definition, see
+// richmenu/richmenu.json.
+type RichMenuConfig struct {
+	Name        string               `json:"name"`
+	ChatBarText string               `json:"chatBarText"`
+	Size        linebot.RichMenuSize `json:"size"`
+	Image       string               `json:"image"` // PNG or JPEG, relative to the config file
+	Areas       []linebot.AreaDetail `json:"areas"`
+}
+
+func loadRichMenuConfig(path string) (*RichMenuConfig, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var conf RichMenuConfig
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if conf.Name == "" || len(conf.Areas) == 0 {
+		return nil, nil, fmt.Errorf("%s must set a name and at least one area", path)
+	}
+	if conf.Image != "" && !filepath.IsAbs(conf.Image) {
+		conf.Image = filepath.Join(filepath.Dir(path), conf.Image)
+	}
+	return &conf, data, nil
+}
+
+// richMenuAPI is the part of the LINE rich menu API used for provisioning.
+type richMenuAPI interface {
+	RichMenus() ([]*linebot.RichMenuResponse, error)
+	Create(menu linebot.RichMenu) (string, error)
+	UploadImage(richMenuID, imagePath string) error
+	DefaultRichMenu() (string, error)
+	SetDefault(richMenuID string) error
+	Delete(richMenuID string) error
+}
+
+type lineRichMenuAPI struct {
+	client *linebot.Client
+}
+
+func (a *lineRichMenuAPI) RichMenus() ([]*linebot.RichMenuResponse, error) {
+	return a.client.GetRichMenuList().Do()
+}
+
+func (a *lineRichMenuAPI) Create(menu linebot.RichMenu) (string, error) {
+	res, err := a.client.CreateRichMenu(menu).Do()
+	if err != nil {
+		return "", err
+	}
+	return res.RichMenuID, nil
+}
+
+func (a *lineRichMenuAPI) UploadImage(richMenuID, imagePath string) error {
+	_, err := a.client.UploadRichMenuImage(richMenuID, imagePath).Do()
+	return err
+}
+
+func (a *lineRichMenuAPI) DefaultRichMenu() (string, error) {
+	res, err := a.client.GetDefaultRichMenu().Do()
+	var apiErr *linebot.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return "", nil // No default menu yet
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.RichMenuID, nil
+}
+
+func (a *lineRichMenuAPI) SetDefault(richMenuID string) error {
+	_, err := a.client.SetDefaultRichMenu(richMenuID).Do()
+	return err
+}
+
+func (a *lineRichMenuAPI) Delete(richMenuID string) error {
+	_, err := a.client.DeleteRichMenu(richMenuID).Do()
+	return err
+}
+
+// runRichMenu implements the "richmenu" subcommand.
+func runRichMenu(args []string) error {
+	flags := flag.NewFlagSet("richmenu", flag.ExitOnError)
+	configPath := flags.String("config", "richmenu/richmenu.json", "rich menu definition")
+	flags.Parse(args)
+
+	conf, data, err := loadRichMenuConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	imagePath := conf.Image
+	if _, err := os.Stat(imagePath); err != nil {
+		log.Printf("Warning: rich menu image %q not found, uploading a placeholder", imagePath)
+		if imagePath, err = writePlaceholderImage(conf); err != nil {
+			return err
+		}
+		defer os.Remove(imagePath)
+	}
+	imageData, err := os.ReadFile(imagePath)
+	if err != nil {
+		return err
+	}
+
+	if err := initializeLineBot(); err != nil {
+		return err
+	}
+	id, err := provisionRichMenu(&lineRichMenuAPI{client: bot}, conf, imagePath, append(data, imageData...))
+	if err != nil {
+		return err
+	}
+	log.Printf("Rich menu %s is the default", id)
+	return nil
+}
+
+// provisionRichMenu makes the menu described by conf the default and returns
+// its ID. The menu name carries a hash of its definition and image, so
+// re-running with the same inputs changes nothing, and older versions of the
+// menu are deleted once the new one is the default.
+func provisionRichMenu(api richMenuAPI, conf *RichMenuConfig, imagePath string, fingerprint []byte) (string, error) {
+	sum := sha1.Sum(fingerprint)
+	name := conf.Name + "@" + hex.EncodeToString(sum[:6])
+
+	menus, err := api.RichMenus()
+	if err != nil {
+		return "", fmt.Errorf("error listing rich menus: %w", err)
+	}
+	id := ""
+	for _, menu := range menus {
+		if menu.Name == name {
+			id = menu.RichMenuID
+			break
+		}
+	}
+
+	if id == "" {
+		id, err = api.Create(linebot.RichMenu{
+			Size:        conf.Size,
+			Selected:    false,
+			Name:        name,
+			ChatBarText: conf.ChatBarText,
+			Areas:       conf.Areas,
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating rich menu: %w", err)
+		}
+		log.Printf("Created rich menu %s (%s)", id, name)
+		if err := api.UploadImage(id, imagePath); err != nil {
+			// A menu without an image cannot be used; don't leave it behind.
+			api.Delete(id)
+			return "", fmt.Errorf("error uploading rich menu image: %w", err)
+		}
+	}
+
+	current, err := api.DefaultRichMenu()
+	if err != nil {
+		return "", fmt.Errorf("error getting the default rich menu: %w", err)
+	}
+	if current != id {
+		if err := api.SetDefault(id); err != nil {
+			return "", fmt.Errorf("error setting the default rich menu: %w", err)
+		}
+	}
+
+	for _, menu := range menus {
+		if menu.RichMenuID != id && strings.HasPrefix(menu.Name, conf.Name+"@") {
+			if err := api.Delete(menu.RichMenuID); err != nil {
+				log.Printf("Failed to delete old rich menu %s: %v", menu.RichMenuID, err)
+				continue
+			}
+			log.Printf("Deleted old rich menu %s (%s)", menu.RichMenuID, menu.Name)
+		}
+	}
+	return id, nil
+}
+
+// writePlaceholderImage draws each area as a coloured tile so the menu can be
+// tried out before a designed image exists.
+func writePlaceholderImage(conf *RichMenuConfig) (string, error) {
+	img := image.NewRGBA(image.Rect(0, 0, conf.Size.Width, conf.Size.Height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	colors := []color.RGBA{{0xF5, 0xA6, 0x23, 0xFF}, {0x7E, 0xD3, 0x21, 0xFF}, {0x4A, 0x90, 0xE2, 0xFF}}
+	for i, area := range conf.Areas {
+		b := area.Bounds
+		rect := image.Rect(b.X, b.Y, b.X+b.Width, b.Y+b.Height).Inset(4)
+		draw.Draw(img, rect, image.NewUniform(colors[i%len(colors)]), image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "richmenu-*.png")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
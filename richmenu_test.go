@@ -0,0 +1,148 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+type fakeRichMenuAPI struct {
+	menus       []*linebot.RichMenuResponse
+	defaultID   string
+	created     int
+	uploads     int
+	setDefaults int
+}
+
+func (f *fakeRichMenuAPI) RichMenus() ([]*linebot.RichMenuResponse, error) {
+	return append([]*linebot.RichMenuResponse{}, f.menus...), nil
+}
+
+func (f *fakeRichMenuAPI) Create(menu linebot.RichMenu) (string, error) {
+	f.created++
+	id := fmt.Sprintf("richmenu-%d", f.created)
+	f.menus = append(f.menus, &linebot.RichMenuResponse{RichMenuID: id, Name: menu.Name, Areas: menu.Areas})
+	return id, nil
+}
+
+func (f *fakeRichMenuAPI) UploadImage(richMenuID, imagePath string) error {
+	f.uploads++
+	return nil
+}
+
+func (f *fakeRichMenuAPI) DefaultRichMenu() (string, error) { return f.defaultID, nil }
+
+func (f *fakeRichMenuAPI) SetDefault(richMenuID string) error {
+	f.setDefaults++
+	f.defaultID = richMenuID
+	return nil
+}
+
+func (f *fakeRichMenuAPI) Delete(richMenuID string) error {
+	for i, menu := range f.menus {
+		if menu.RichMenuID == richMenuID {
+			f.menus = append(f.menus[:i], f.menus[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("rich menu %s not found", richMenuID)
+}
+
+func TestRichMenuConfig(t *testing.T) {
+	conf, _, err := loadRichMenuConfig("richmenu/richmenu.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, area := range conf.Areas {
+		b := area.Bounds
+		if b.X+b.Width > conf.Size.Width || b.Y+b.Height > conf.Size.Height {
+			t.Errorf("Area %+v is outside the menu", b)
+		}
+		if area.Action.Type == linebot.RichMenuActionTypeMessage && area.Action.Text == "" {
+			t.Errorf("Area %+v sends no text", b)
+		}
+		// Taps must not be sent to the AI search.
+		if area.Action.Type == linebot.RichMenuActionTypeMessage && !menuCommands[area.Action.Text] {
+			t.Errorf("Menu text %q is not in menuCommands", area.Action.Text)
+		}
+	}
+}
+
+func TestProvisionRichMenuIsIdempotent(t *testing.T) {
+	conf, data, err := loadRichMenuConfig("richmenu/richmenu.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeRichMenuAPI{menus: []*linebot.RichMenuResponse{
+		{RichMenuID: "old", Name: conf.Name + "@000000000000"},
+		{RichMenuID: "other", Name: "another-menu"},
+	}}
+
+	id, err := provisionRichMenu(api, conf, "menu.png", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.created != 1 || api.uploads != 1 || api.defaultID != id {
+		t.Errorf("Expected the menu to be created and made default, got %+v", api)
+	}
+	if len(api.menus) != 2 || api.menus[0].RichMenuID != "other" {
+		t.Errorf("Expected only the old version to be deleted, got %+v", api.menus)
+	}
+
+	again, err := provisionRichMenu(api, conf, "menu.png", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id || api.created != 1 || api.uploads != 1 || api.setDefaults != 1 {
+		t.Errorf("Expected re-running to change nothing, got %s %+v", again, api)
+	}
+
+	if _, err := provisionRichMenu(api, conf, "menu.png", append(data, 'x')); err != nil {
+		t.Fatal(err)
+	}
+	if api.created != 2 || len(api.menus) != 2 {
+		t.Errorf("Expected a changed definition to replace the menu, got %+v", api.menus)
+	}
+}
+
+func TestWritePlaceholderImage(t *testing.T) {
+	conf, _, err := loadRichMenuConfig("richmenu/richmenu.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := writePlaceholderImage(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	if info, err := os.Stat(path); err != nil || info.Size() > 1<<20 {
+		t.Errorf("Expected a placeholder under LINE's 1 MB limit, got %v %v", info, err)
+	}
+}
+
+func TestCityFromAddress(t *testing.T) {
+	for address, want := range map[string]string{
+		"100台灣台北市中正區重慶南路一段122號": "臺北市",
+		"臺灣省花蓮縣花蓮市":             "花蓮縣",
+		"新北市板橋區":                "新北市",
+		"1-1 Chiyoda, Tokyo":    "",
+	} {
+		if got := cityFromAddress(address); got != want {
+			t.Errorf("cityFromAddress(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// petPagePath is where the bot serves a shareable page per pet, as /pet/{id}.
+const petPagePath = "/pet/"
+
+//go:embed web/*.html
+var webPages embed.FS
+
+var petPageTemplates = template.Must(template.New("web").Funcs(template.FuncMap{
+	// tel marks a tel: link as safe; html/template only trusts web links.
+	"tel": func(uri string) template.URL {
+		if !strings.HasPrefix(uri, "tel:") {
+			return ""
+		}
+		return template.URL(uri)
+	},
+}).ParseFS(webPages, "web/pet.html", "web/gone.html"))
+
+// petPageURL returns the address of the pet's shareable page, or "" if
+// PUBLIC_URL is not set.
+func petPageURL(petID int) string {
+	if PublicURL == "" {
+		return ""
+	}
+	return PublicURL + petPagePath + strconv.Itoa(petID)
+}
+
+// petPageData is what pet.html is rendered with.
+type petPageData struct {
+	Pet         PetView
+	Title       string
+	Description string
+}
+
+// petPageHandler serves /pet/{id}: a small page about a listed pet whose
+// OpenGraph tags make shared links show the photo and name in LINE and other
+// apps.
+func petPageHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, petPagePath))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var buf bytes.Buffer
+	status := http.StatusOK
+	if pet := PetDB.GetPet(id); pet != nil {
+		view := newPetView(pet, petImageURL(pet, heroVariant), petProfiles.Cached(pet))
+		err = petPageTemplates.ExecuteTemplate(&buf, "pet.html", petPageData{
+			Pet:         view,
+			Title:       petPageTitle(view),
+			Description: petPageDescription(view),
+		})
+	} else {
+		// Links keep being shared after the pet is adopted.
+		status = http.StatusNotFound
+		err = petPageTemplates.ExecuteTemplate(&buf, "gone.html", placeholderImageURL)
+	}
+	if err != nil {
+		log.Printf("Failed to render page for pet %d: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+func petPageTitle(pet PetView) string {
+	kind := pet.Kind
+	if kind == "" {
+		kind = "毛孩"
+	}
+	return pet.Name + "｜等待認養的" + kind
+}
+
+// petPageDescription is the preview text: the profile if there is one,
+// otherwise the basic facts.
+func petPageDescription(pet PetView) string {
+	if pet.Profile != "" {
+		return pet.Profile
+	}
+	var facts []string
+	for _, f := range []string{pet.Sex, pet.BodyType, pet.Color, pet.Age} {
+		if f != "" {
+			facts = append(facts, f)
+		}
+	}
+	description := strings.Join(facts, "・")
+	if pet.Shelter != "" {
+		if description != "" {
+			description += "，"
+		}
+		description += "目前在" + pet.Shelter
+	}
+	return description
+}
@@ -0,0 +1,101 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPetPageHandler(t *testing.T) {
+	savedDB, savedURL := PetDB, PublicURL
+	defer func() { PetDB, PublicURL = savedDB, savedURL }()
+	PublicURL = "https://bot.example"
+	PetDB = new(Pets)
+	PetDB.LoadPets(TaiwanPets{
+		{AnimalID: 5, AnimalSubid: "<b>Lucky</b>", AnimalKind: "狗", AnimalSex: "M", AnimalBodytype: "MEDIUM",
+			AlbumFile: "http://shelter.example/5.jpg", ShelterName: "新北市板橋區公立動物之家", ShelterTel: "02-29596353"},
+	})
+
+	rec := httptest.NewRecorder()
+	petPageHandler(rec, httptest.NewRequest(http.MethodGet, "/pet/5", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	page := rec.Body.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="&lt;b&gt;Lucky&lt;/b&gt;｜等待認養的狗">`,
+		`<meta property="og:image" content="https://bot.example/img/5">`,
+		`<meta property="og:url" content="https://bot.example/pet/5">`,
+		`<meta property="og:description" content="公・中型`,
+		`href="tel:0229596353"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Page is missing %s", want)
+		}
+	}
+	if strings.Contains(page, "<b>Lucky") {
+		t.Error("Expected the pet name to be escaped")
+	}
+
+	for path, status := range map[string]int{"/pet/99": http.StatusNotFound, "/pet/abc": http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		petPageHandler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != status {
+			t.Errorf("%s: got status %d, want %d", path, rec.Code, status)
+		}
+	}
+}
+
+func TestShareTextLinksToPage(t *testing.T) {
+	saved := PublicURL
+	defer func() { PublicURL = saved }()
+
+	pet := &Pet{ID: 5, Name: "Lucky", Variety: "狗", ImageName: "http://shelter.example/5.jpg"}
+	PublicURL = "https://bot.example"
+	text := generateShareText(newPetView(pet, petImageURL(pet, heroVariant), ""))
+	if !strings.HasSuffix(text, "https://bot.example/pet/5") || strings.Contains(text, "/img/") {
+		t.Errorf("Expected the share text to end with the page link, got %q", text)
+	}
+
+	PublicURL = ""
+	text = generateShareText(newPetView(pet, petImageURL(pet, heroVariant), ""))
+	if !strings.Contains(text, "名字：Lucky") {
+		t.Errorf("Expected the details without a public page, got %q", text)
+	}
+}
+
+func TestShareURIFitsLINELimit(t *testing.T) {
+	saved := PublicURL
+	defer func() { PublicURL = saved }()
+	PublicURL = ""
+
+	pet := &Pet{ID: 5, Name: "Lucky", Variety: "狗", Sex: "M", Type: "MEDIUM", Age: "ADULT", Phone: "02-87913254、02-87913255",
+		Resettlement: "臺北市動物之家(臺北市內湖區潭美街852號)", ImageName: "http://shelter.example/5.jpg"}
+	profile := strings.Repeat("親人又愛撒嬌", 10) // 60 characters, the most the prompt asks for
+	for _, p := range []string{profile, profile + profile} {
+		uri := shareURI(newPetView(pet, "https://asms.coa.gov.tw/amlapp/upload/pic/5.jpg", p))
+		if len(uri) > maxURILength {
+			t.Errorf("Share URI is %d bytes, over LINE's %d", len(uri), maxURILength)
+		}
+		if !strings.HasPrefix(uri, "line://msg/text/?") {
+			t.Errorf("Unexpected share URI %s", uri)
+		}
+	}
+
+	if text := generateShareText(newPetView(pet, "", profile+profile)); strings.Count(text, "親人又愛撒嬌") > 10 {
+		t.Errorf("Expected the profile to be cut to %d characters, got %q", maxShareProfile, text)
+	}
+}
@@ -0,0 +1,157 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Favorite is a reference to a pet a user favourited. Pet details are looked
+// up in the live catalogue so they never go stale.
+type Favorite struct {
+	PetID   int
+	AddedAt time.Time
+}
+
+// FavoritesStore persists the pets each user has favourited.
+type FavoritesStore interface {
+	// AddFavorite saves a pet to the user's favourites. Adding it again keeps
+	// the original time.
+	AddFavorite(ctx context.Context, userID string, petID int) error
+	// Favorites returns the user's favourites, most recently added first.
+	Favorites(ctx context.Context, userID string) ([]Favorite, error)
+	// RemoveFavorite deletes a pet from the user's favourites.
+	RemoveFavorite(ctx context.Context, userID string, petID int) error
+	// ClearFavorites deletes all of the user's favourites.
+	ClearFavorites(ctx context.Context, userID string) error
+	// AllFavorites returns every user's favourites keyed by user ID.
+	AllFavorites(ctx context.Context) (map[string][]Favorite, error)
+}
+
+// SettingsStore persists per-user preferences.
+type SettingsStore interface {
+	// NotificationsMuted reports whether the user opted out of push
+	// notifications.
+	NotificationsMuted(ctx context.Context, userID string) (bool, error)
+	SetNotificationsMuted(ctx context.Context, userID string, muted bool) error
+}
+
+// Subscription is a saved search whose new matches are pushed to the user.
+type Subscription struct {
+	ID        int64
+	Criteria  SearchCriteria
+	CreatedAt time.Time
+}
+
+// SubscriptionStore persists each user's saved searches.
+type SubscriptionStore interface {
+	// AddSubscription saves criteria for the user. Saving the same criteria
+	// again is a no-op.
+	AddSubscription(ctx context.Context, userID string, criteria SearchCriteria) error
+	// Subscriptions returns the user's subscriptions, oldest first.
+	Subscriptions(ctx context.Context, userID string) ([]Subscription, error)
+	// RemoveSubscription deletes one of the user's subscriptions.
+	RemoveSubscription(ctx context.Context, userID string, id int64) error
+	// ClearSubscriptions deletes all of the user's subscriptions.
+	ClearSubscriptions(ctx context.Context, userID string) error
+	// AllSubscriptions returns every user's subscriptions keyed by user ID.
+	AllSubscriptions(ctx context.Context) (map[string][]Subscription, error)
+}
+
+// ShortlistEntry is a pet on a group's shared shortlist.
+type ShortlistEntry struct {
+	PetID   int
+	AddedBy string
+	AddedAt time.Time
+	Voters  []string
+}
+
+// GroupStore persists the shared shortlists of groups and rooms, keyed by
+// their GroupID or RoomID.
+type GroupStore interface {
+	// AddToShortlist adds a pet to the group's shortlist. Adding it again
+	// keeps the original entry.
+	AddToShortlist(ctx context.Context, groupID string, petID int, userID string) error
+	// Vote records the user's vote for a pet, adding the pet to the shortlist
+	// if needed. It reports false if the user had already voted for it.
+	Vote(ctx context.Context, groupID string, petID int, userID string) (bool, error)
+	// Shortlist returns the group's shortlist, most votes first.
+	Shortlist(ctx context.Context, groupID string) ([]ShortlistEntry, error)
+	// RemoveFromShortlist deletes a pet and its votes from the shortlist.
+	RemoveFromShortlist(ctx context.Context, groupID string, petID int) error
+	// ClearShortlist deletes the group's whole shortlist.
+	ClearShortlist(ctx context.Context, groupID string) error
+}
+
+// Store is implemented by every storage backend.
+type Store interface {
+	FavoritesStore
+	SettingsStore
+	SubscriptionStore
+	GroupStore
+}
+
+// sortFavorites orders favourites most recently added first.
+func sortFavorites(favs []Favorite) {
+	sort.Slice(favs, func(i, j int) bool { return favs[i].AddedAt.After(favs[j].AddedAt) })
+}
+
+// sortSubscriptions orders subscriptions oldest first.
+func sortSubscriptions(subs []Subscription) {
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+}
+
+// sortShortlist orders a shortlist by votes, then by the time added.
+func sortShortlist(entries []ShortlistEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if len(entries[i].Voters) != len(entries[j].Voters) {
+			return len(entries[i].Voters) > len(entries[j].Voters)
+		}
+		return entries[i].AddedAt.Before(entries[j].AddedAt)
+	})
+}
+
+// newStore opens the storage backend selected by STORE_BACKEND:
+// "firebase" (needs FIREBASE_DB), "sqlite" (SQLITE_PATH, default
+// petneedme.db) or "memory". It defaults to Firebase when FIREBASE_DB is set
+// and to memory otherwise.
+func newStore(ctx context.Context) (Store, error) {
+	backend := os.Getenv("STORE_BACKEND")
+	if backend == "" {
+		backend = "memory"
+		if os.Getenv("FIREBASE_DB") != "" {
+			backend = "firebase"
+		}
+	}
+
+	switch backend {
+	case "firebase":
+		return newFirebaseStore(ctx, os.Getenv("FIREBASE_DB"))
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "petneedme.db"
+		}
+		return newSQLiteStore(path)
+	case "memory":
+		log.Println("Warning: using in-memory storage, favorites will be lost on restart.")
+		return newMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,314 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	firebase "firebase.google.com/go/v4"
+	"firebase.google.com/go/v4/db"
+)
+
+// firebaseStore keeps data in the Firebase Realtime Database under /petneedme.
+type firebaseStore struct {
+	client *db.Client
+}
+
+func newFirebaseStore(ctx context.Context, databaseURL string) (*firebaseStore, error) {
+	if databaseURL == "" {
+		return nil, fmt.Errorf("FIREBASE_DB environment variable must be set")
+	}
+	conf := &firebase.Config{DatabaseURL: databaseURL}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing app: %w", err)
+	}
+	client, err := app.Database(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Database client: %w", err)
+	}
+	return &firebaseStore{client: client}, nil
+}
+
+// firebaseFavorite is stored under /petneedme/favorites/{userID}/{petID}.
+// AddedAt is in Unix milliseconds. Older records held a full copy of the pet
+// instead; those fields are ignored and AddedAt reads as 0.
+type firebaseFavorite struct {
+	AddedAt int64 `json:"AddedAt"`
+}
+
+func (s *firebaseStore) favoritesRef(userID string) *db.Ref {
+	return s.client.NewRef("/petneedme/favorites/" + userID)
+}
+
+func (s *firebaseStore) AddFavorite(ctx context.Context, userID string, petID int) error {
+	petRef := s.favoritesRef(userID).Child(strconv.Itoa(petID)) // Use pet ID as the key to avoid duplicates
+	err := petRef.Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var fav firebaseFavorite
+		if err := node.Unmarshal(&fav); err != nil {
+			return nil, err
+		}
+		if fav.AddedAt == 0 {
+			fav.AddedAt = time.Now().UnixMilli()
+		}
+		return fav, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error adding favorite to Firebase for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) Favorites(ctx context.Context, userID string) ([]Favorite, error) {
+	var favorites map[string]firebaseFavorite
+	if err := s.favoritesRef(userID).Get(ctx, &favorites); err != nil {
+		return nil, fmt.Errorf("error getting favorites from Firebase for user %s: %w", userID, err)
+	}
+	return toFavorites(favorites), nil
+}
+
+func (s *firebaseStore) AllFavorites(ctx context.Context) (map[string][]Favorite, error) {
+	var all map[string]map[string]firebaseFavorite
+	if err := s.client.NewRef("/petneedme/favorites").Get(ctx, &all); err != nil {
+		return nil, fmt.Errorf("error getting all favorites from Firebase: %w", err)
+	}
+	favs := make(map[string][]Favorite, len(all))
+	for userID, favorites := range all {
+		favs[userID] = toFavorites(favorites)
+	}
+	return favs, nil
+}
+
+// toFavorites converts the favourites stored under a user, keyed by pet ID.
+func toFavorites(favorites map[string]firebaseFavorite) []Favorite {
+	favs := make([]Favorite, 0, len(favorites))
+	for key, fav := range favorites {
+		petID, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		favs = append(favs, Favorite{PetID: petID, AddedAt: time.UnixMilli(fav.AddedAt)})
+	}
+	sortFavorites(favs)
+	return favs
+}
+
+func (s *firebaseStore) RemoveFavorite(ctx context.Context, userID string, petID int) error {
+	if err := s.favoritesRef(userID).Child(strconv.Itoa(petID)).Delete(ctx); err != nil {
+		return fmt.Errorf("error removing favorite from Firebase for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) ClearFavorites(ctx context.Context, userID string) error {
+	if err := s.favoritesRef(userID).Delete(ctx); err != nil {
+		return fmt.Errorf("error clearing favorites from Firebase for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) settingsRef(userID string) *db.Ref {
+	return s.client.NewRef("/petneedme/settings/" + userID)
+}
+
+func (s *firebaseStore) NotificationsMuted(ctx context.Context, userID string) (bool, error) {
+	var muted bool
+	if err := s.settingsRef(userID).Child("notifications_muted").Get(ctx, &muted); err != nil {
+		return false, fmt.Errorf("error getting settings from Firebase for user %s: %w", userID, err)
+	}
+	return muted, nil
+}
+
+func (s *firebaseStore) SetNotificationsMuted(ctx context.Context, userID string, muted bool) error {
+	if err := s.settingsRef(userID).Child("notifications_muted").Set(ctx, muted); err != nil {
+		return fmt.Errorf("error saving settings to Firebase for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// firebaseSubscription is stored under /petneedme/subscriptions/{userID}/{id},
+// where id is the creation time in Unix nanoseconds.
+type firebaseSubscription struct {
+	Criteria SearchCriteria `json:"Criteria"`
+}
+
+func (s *firebaseStore) subscriptionsRef(userID string) *db.Ref {
+	return s.client.NewRef("/petneedme/subscriptions/" + userID)
+}
+
+func (s *firebaseStore) AddSubscription(ctx context.Context, userID string, criteria SearchCriteria) error {
+	err := s.subscriptionsRef(userID).Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var subs map[string]firebaseSubscription
+		if err := node.Unmarshal(&subs); err != nil {
+			return nil, err
+		}
+		if subs == nil {
+			subs = make(map[string]firebaseSubscription)
+		}
+		for _, sub := range subs {
+			if sub.Criteria == criteria {
+				return subs, nil
+			}
+		}
+		subs[strconv.FormatInt(time.Now().UnixNano(), 10)] = firebaseSubscription{Criteria: criteria}
+		return subs, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error adding subscription to Firebase for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) Subscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	var subs map[string]firebaseSubscription
+	if err := s.subscriptionsRef(userID).Get(ctx, &subs); err != nil {
+		return nil, fmt.Errorf("error getting subscriptions from Firebase for user %s: %w", userID, err)
+	}
+	return toSubscriptions(subs), nil
+}
+
+func (s *firebaseStore) RemoveSubscription(ctx context.Context, userID string, id int64) error {
+	if err := s.subscriptionsRef(userID).Child(strconv.FormatInt(id, 10)).Delete(ctx); err != nil {
+		return fmt.Errorf("error removing subscription from Firebase for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) ClearSubscriptions(ctx context.Context, userID string) error {
+	if err := s.subscriptionsRef(userID).Delete(ctx); err != nil {
+		return fmt.Errorf("error clearing subscriptions from Firebase for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) AllSubscriptions(ctx context.Context) (map[string][]Subscription, error) {
+	var all map[string]map[string]firebaseSubscription
+	if err := s.client.NewRef("/petneedme/subscriptions").Get(ctx, &all); err != nil {
+		return nil, fmt.Errorf("error getting all subscriptions from Firebase: %w", err)
+	}
+	subs := make(map[string][]Subscription, len(all))
+	for userID, userSubs := range all {
+		subs[userID] = toSubscriptions(userSubs)
+	}
+	return subs, nil
+}
+
+// toSubscriptions converts the subscriptions stored under a user, keyed by ID.
+func toSubscriptions(stored map[string]firebaseSubscription) []Subscription {
+	subs := make([]Subscription, 0, len(stored))
+	for key, sub := range stored {
+		id, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			continue
+		}
+		subs = append(subs, Subscription{ID: id, Criteria: sub.Criteria, CreatedAt: time.Unix(0, id)})
+	}
+	sortSubscriptions(subs)
+	return subs
+}
+
+// firebaseShortlistEntry is stored under /petneedme/groups/{groupID}/{petID}.
+// AddedAt is in Unix milliseconds and Votes holds the voters' user IDs.
+type firebaseShortlistEntry struct {
+	AddedBy string          `json:"AddedBy"`
+	AddedAt int64           `json:"AddedAt"`
+	Votes   map[string]bool `json:"Votes,omitempty"`
+}
+
+func (s *firebaseStore) shortlistRef(groupID string) *db.Ref {
+	return s.client.NewRef("/petneedme/groups/" + groupID)
+}
+
+// updateShortlistEntry adds the pet to the shortlist if needed and, when vote
+// is set, records the user's vote. It reports whether a new vote was counted.
+func (s *firebaseStore) updateShortlistEntry(ctx context.Context, groupID string, petID int, userID string, vote bool) (bool, error) {
+	counted := false
+	ref := s.shortlistRef(groupID).Child(strconv.Itoa(petID))
+	err := ref.Transaction(ctx, func(node db.TransactionNode) (interface{}, error) {
+		var entry firebaseShortlistEntry
+		if err := node.Unmarshal(&entry); err != nil {
+			return nil, err
+		}
+		if entry.AddedAt == 0 {
+			entry.AddedBy = userID
+			entry.AddedAt = time.Now().UnixMilli()
+		}
+		counted = false
+		if vote && !entry.Votes[userID] {
+			if entry.Votes == nil {
+				entry.Votes = make(map[string]bool)
+			}
+			entry.Votes[userID] = true
+			counted = true
+		}
+		return entry, nil
+	})
+	return counted, err
+}
+
+func (s *firebaseStore) AddToShortlist(ctx context.Context, groupID string, petID int, userID string) error {
+	if _, err := s.updateShortlistEntry(ctx, groupID, petID, userID, false); err != nil {
+		return fmt.Errorf("error adding to Firebase shortlist for group %s: %w", groupID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) Vote(ctx context.Context, groupID string, petID int, userID string) (bool, error) {
+	counted, err := s.updateShortlistEntry(ctx, groupID, petID, userID, true)
+	if err != nil {
+		return false, fmt.Errorf("error voting in Firebase for group %s: %w", groupID, err)
+	}
+	return counted, nil
+}
+
+func (s *firebaseStore) Shortlist(ctx context.Context, groupID string) ([]ShortlistEntry, error) {
+	var stored map[string]firebaseShortlistEntry
+	if err := s.shortlistRef(groupID).Get(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("error getting Firebase shortlist for group %s: %w", groupID, err)
+	}
+	entries := make([]ShortlistEntry, 0, len(stored))
+	for key, e := range stored {
+		petID, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		entry := ShortlistEntry{PetID: petID, AddedBy: e.AddedBy, AddedAt: time.UnixMilli(e.AddedAt)}
+		for userID, voted := range e.Votes {
+			if voted {
+				entry.Voters = append(entry.Voters, userID)
+			}
+		}
+		sort.Strings(entry.Voters)
+		entries = append(entries, entry)
+	}
+	sortShortlist(entries)
+	return entries, nil
+}
+
+func (s *firebaseStore) RemoveFromShortlist(ctx context.Context, groupID string, petID int) error {
+	if err := s.shortlistRef(groupID).Child(strconv.Itoa(petID)).Delete(ctx); err != nil {
+		return fmt.Errorf("error removing from Firebase shortlist for group %s: %w", groupID, err)
+	}
+	return nil
+}
+
+func (s *firebaseStore) ClearShortlist(ctx context.Context, groupID string) error {
+	if err := s.shortlistRef(groupID).Delete(ctx); err != nil {
+		return fmt.Errorf("error clearing Firebase shortlist for group %s: %w", groupID, err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps data in process memory. It is meant for tests and local
+// development; everything is lost on restart.
+type memoryStore struct {
+	mu        sync.Mutex
+	favorites map[string]map[int]time.Time
+	muted     map[string]bool
+	subs      map[string][]Subscription
+	groups    map[string]map[int]*ShortlistEntry
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		favorites: make(map[string]map[int]time.Time),
+		muted:     make(map[string]bool),
+		subs:      make(map[string][]Subscription),
+		groups:    make(map[string]map[int]*ShortlistEntry),
+	}
+}
+
+func (s *memoryStore) AddFavorite(ctx context.Context, userID string, petID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.favorites[userID] == nil {
+		s.favorites[userID] = make(map[int]time.Time)
+	}
+	if _, ok := s.favorites[userID][petID]; !ok {
+		s.favorites[userID][petID] = time.Now()
+	}
+	return nil
+}
+
+func (s *memoryStore) Favorites(ctx context.Context, userID string) ([]Favorite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.favoritesLocked(userID), nil
+}
+
+func (s *memoryStore) favoritesLocked(userID string) []Favorite {
+	favs := make([]Favorite, 0, len(s.favorites[userID]))
+	for petID, addedAt := range s.favorites[userID] {
+		favs = append(favs, Favorite{PetID: petID, AddedAt: addedAt})
+	}
+	sortFavorites(favs)
+	return favs
+}
+
+func (s *memoryStore) RemoveFavorite(ctx context.Context, userID string, petID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.favorites[userID], petID)
+	return nil
+}
+
+func (s *memoryStore) ClearFavorites(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.favorites, userID)
+	return nil
+}
+
+func (s *memoryStore) AllFavorites(ctx context.Context) (map[string][]Favorite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make(map[string][]Favorite, len(s.favorites))
+	for userID := range s.favorites {
+		if favs := s.favoritesLocked(userID); len(favs) > 0 {
+			all[userID] = favs
+		}
+	}
+	return all, nil
+}
+
+func (s *memoryStore) NotificationsMuted(ctx context.Context, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.muted[userID], nil
+}
+
+func (s *memoryStore) SetNotificationsMuted(ctx context.Context, userID string, muted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.muted[userID] = muted
+	return nil
+}
+
+func (s *memoryStore) AddSubscription(ctx context.Context, userID string, criteria SearchCriteria) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs[userID] {
+		if sub.Criteria == criteria {
+			return nil
+		}
+	}
+	now := time.Now()
+	s.subs[userID] = append(s.subs[userID], Subscription{ID: now.UnixNano(), Criteria: criteria, CreatedAt: now})
+	return nil
+}
+
+func (s *memoryStore) Subscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Subscription{}, s.subs[userID]...), nil
+}
+
+func (s *memoryStore) RemoveSubscription(ctx context.Context, userID string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := s.subs[userID][:0]
+	for _, sub := range s.subs[userID] {
+		if sub.ID != id {
+			subs = append(subs, sub)
+		}
+	}
+	s.subs[userID] = subs
+	return nil
+}
+
+func (s *memoryStore) ClearSubscriptions(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, userID)
+	return nil
+}
+
+func (s *memoryStore) AllSubscriptions(ctx context.Context) (map[string][]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make(map[string][]Subscription, len(s.subs))
+	for userID, subs := range s.subs {
+		if len(subs) > 0 {
+			all[userID] = append([]Subscription{}, subs...)
+		}
+	}
+	return all, nil
+}
+
+func (s *memoryStore) AddToShortlist(ctx context.Context, groupID string, petID int, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shortlistEntryLocked(groupID, petID, userID)
+	return nil
+}
+
+// shortlistEntryLocked returns the group's entry for the pet, creating it if
+// needed. s.mu must be held.
+func (s *memoryStore) shortlistEntryLocked(groupID string, petID int, userID string) *ShortlistEntry {
+	if s.groups[groupID] == nil {
+		s.groups[groupID] = make(map[int]*ShortlistEntry)
+	}
+	entry, ok := s.groups[groupID][petID]
+	if !ok {
+		entry = &ShortlistEntry{PetID: petID, AddedBy: userID, AddedAt: time.Now()}
+		s.groups[groupID][petID] = entry
+	}
+	return entry
+}
+
+func (s *memoryStore) Vote(ctx context.Context, groupID string, petID int, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.shortlistEntryLocked(groupID, petID, userID)
+	for _, voter := range entry.Voters {
+		if voter == userID {
+			return false, nil
+		}
+	}
+	entry.Voters = append(entry.Voters, userID)
+	return true, nil
+}
+
+func (s *memoryStore) Shortlist(ctx context.Context, groupID string) ([]ShortlistEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]ShortlistEntry, 0, len(s.groups[groupID]))
+	for _, entry := range s.groups[groupID] {
+		e := *entry
+		e.Voters = append([]string{}, entry.Voters...)
+		entries = append(entries, e)
+	}
+	sortShortlist(entries)
+	return entries, nil
+}
+
+func (s *memoryStore) RemoveFromShortlist(ctx context.Context, groupID string, petID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.groups[groupID], petID)
+	return nil
+}
+
+func (s *memoryStore) ClearShortlist(ctx context.Context, groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.groups, groupID)
+	return nil
+}
@@ -0,0 +1,372 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema creates the tables used by sqliteStore.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS favorites (
+	user_id  TEXT    NOT NULL,
+	pet_id   INTEGER NOT NULL,
+	added_at INTEGER NOT NULL,
+	PRIMARY KEY (user_id, pet_id)
+);
+CREATE TABLE IF NOT EXISTS user_settings (
+	user_id             TEXT    PRIMARY KEY,
+	notifications_muted INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS subscriptions (
+	user_id  TEXT    NOT NULL,
+	id       INTEGER NOT NULL,
+	criteria TEXT    NOT NULL,
+	PRIMARY KEY (user_id, id),
+	UNIQUE (user_id, criteria)
+);
+CREATE TABLE IF NOT EXISTS group_shortlist (
+	group_id TEXT    NOT NULL,
+	pet_id   INTEGER NOT NULL,
+	added_by TEXT    NOT NULL,
+	added_at INTEGER NOT NULL,
+	PRIMARY KEY (group_id, pet_id)
+);
+CREATE TABLE IF NOT EXISTS group_votes (
+	group_id TEXT    NOT NULL,
+	pet_id   INTEGER NOT NULL,
+	user_id  TEXT    NOT NULL,
+	PRIMARY KEY (group_id, pet_id, user_id)
+);
+`
+
+// sqliteStore keeps data in a local SQLite file, for self-hosting without
+// Firebase.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database %s: %w", path, err)
+	}
+	// SQLite allows a single writer; serialise access through one connection.
+	db.SetMaxOpenConns(1)
+	if err := migrateFavoritesByReference(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating SQLite favorites: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating SQLite schema: %w", err)
+	}
+	if err := migrateFavoriteTimesToNanos(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating SQLite favorite times: %w", err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// migrateFavoritesByReference drops the pet copy that older databases kept in
+// the favorites table.
+func migrateFavoritesByReference(db *sql.DB) error {
+	var hasPet int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('favorites') WHERE name = 'pet'`).Scan(&hasPet)
+	if err != nil || hasPet == 0 {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE favorites DROP COLUMN pet`)
+	return err
+}
+
+// migrateFavoriteTimesToNanos converts the added_at times that older
+// databases kept in Unix seconds to nanoseconds, so those favourites keep
+// their place in the order. Any value below 1e11 is in seconds: as
+// nanoseconds it would be in 1970.
+func migrateFavoriteTimesToNanos(db *sql.DB) error {
+	_, err := db.Exec(`UPDATE favorites SET added_at = added_at * 1000000000 WHERE added_at < 100000000000`)
+	return err
+}
+
+func (s *sqliteStore) AddFavorite(ctx context.Context, userID string, petID int) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO favorites (user_id, pet_id, added_at) VALUES (?, ?, ?)
+		 ON CONFLICT (user_id, pet_id) DO NOTHING`,
+		userID, petID, time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("error adding favorite to SQLite for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) Favorites(ctx context.Context, userID string) ([]Favorite, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT pet_id, added_at FROM favorites WHERE user_id = ? ORDER BY added_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting favorites from SQLite for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	favs := []Favorite{}
+	for rows.Next() {
+		var fav Favorite
+		var addedAt int64
+		if err := rows.Scan(&fav.PetID, &addedAt); err != nil {
+			return nil, err
+		}
+		fav.AddedAt = time.Unix(0, addedAt)
+		favs = append(favs, fav)
+	}
+	return favs, rows.Err()
+}
+
+func (s *sqliteStore) AllFavorites(ctx context.Context) (map[string][]Favorite, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT user_id, pet_id, added_at FROM favorites ORDER BY added_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting all favorites from SQLite: %w", err)
+	}
+	defer rows.Close()
+
+	all := make(map[string][]Favorite)
+	for rows.Next() {
+		var userID string
+		var fav Favorite
+		var addedAt int64
+		if err := rows.Scan(&userID, &fav.PetID, &addedAt); err != nil {
+			return nil, err
+		}
+		fav.AddedAt = time.Unix(0, addedAt)
+		all[userID] = append(all[userID], fav)
+	}
+	return all, rows.Err()
+}
+
+func (s *sqliteStore) RemoveFavorite(ctx context.Context, userID string, petID int) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM favorites WHERE user_id = ? AND pet_id = ?`, userID, petID); err != nil {
+		return fmt.Errorf("error removing favorite from SQLite for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) ClearFavorites(ctx context.Context, userID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM favorites WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("error clearing favorites from SQLite for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) NotificationsMuted(ctx context.Context, userID string) (bool, error) {
+	var muted bool
+	err := s.db.QueryRowContext(ctx, `SELECT notifications_muted FROM user_settings WHERE user_id = ?`, userID).Scan(&muted)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting settings from SQLite for user %s: %w", userID, err)
+	}
+	return muted, nil
+}
+
+func (s *sqliteStore) SetNotificationsMuted(ctx context.Context, userID string, muted bool) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO user_settings (user_id, notifications_muted) VALUES (?, ?)
+		 ON CONFLICT (user_id) DO UPDATE SET notifications_muted = excluded.notifications_muted`,
+		userID, muted)
+	if err != nil {
+		return fmt.Errorf("error saving settings to SQLite for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) AddSubscription(ctx context.Context, userID string, criteria SearchCriteria) error {
+	data, err := json.Marshal(criteria)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO subscriptions (user_id, id, criteria) VALUES (?, ?, ?)
+		 ON CONFLICT (user_id, criteria) DO NOTHING`,
+		userID, time.Now().UnixNano(), string(data))
+	if err != nil {
+		return fmt.Errorf("error adding subscription to SQLite for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) Subscriptions(ctx context.Context, userID string) ([]Subscription, error) {
+	all, err := s.querySubscriptions(ctx, `SELECT user_id, id, criteria FROM subscriptions WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting subscriptions from SQLite for user %s: %w", userID, err)
+	}
+	subs := all[userID]
+	if subs == nil {
+		subs = []Subscription{}
+	}
+	return subs, nil
+}
+
+func (s *sqliteStore) RemoveSubscription(ctx context.Context, userID string, id int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM subscriptions WHERE user_id = ? AND id = ?`, userID, id); err != nil {
+		return fmt.Errorf("error removing subscription from SQLite for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) ClearSubscriptions(ctx context.Context, userID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM subscriptions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("error clearing subscriptions from SQLite for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) AllSubscriptions(ctx context.Context) (map[string][]Subscription, error) {
+	all, err := s.querySubscriptions(ctx, `SELECT user_id, id, criteria FROM subscriptions ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting all subscriptions from SQLite: %w", err)
+	}
+	return all, nil
+}
+
+// querySubscriptions groups the (user_id, id, criteria) rows by user.
+func (s *sqliteStore) querySubscriptions(ctx context.Context, query string, args ...interface{}) (map[string][]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	all := make(map[string][]Subscription)
+	for rows.Next() {
+		var userID, criteria string
+		var sub Subscription
+		if err := rows.Scan(&userID, &sub.ID, &criteria); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(criteria), &sub.Criteria); err != nil {
+			return nil, err
+		}
+		sub.CreatedAt = time.Unix(0, sub.ID)
+		all[userID] = append(all[userID], sub)
+	}
+	return all, rows.Err()
+}
+
+func (s *sqliteStore) AddToShortlist(ctx context.Context, groupID string, petID int, userID string) error {
+	if err := s.addToShortlist(ctx, s.db, groupID, petID, userID); err != nil {
+		return fmt.Errorf("error adding to SQLite shortlist for group %s: %w", groupID, err)
+	}
+	return nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *sqliteStore) addToShortlist(ctx context.Context, db execer, groupID string, petID int, userID string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO group_shortlist (group_id, pet_id, added_by, added_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (group_id, pet_id) DO NOTHING`,
+		groupID, petID, userID, time.Now().UnixNano())
+	return err
+}
+
+func (s *sqliteStore) Vote(ctx context.Context, groupID string, petID int, userID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := s.addToShortlist(ctx, tx, groupID, petID, userID); err != nil {
+		return false, fmt.Errorf("error adding to SQLite shortlist for group %s: %w", groupID, err)
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO group_votes (group_id, pet_id, user_id) VALUES (?, ?, ?)
+		 ON CONFLICT (group_id, pet_id, user_id) DO NOTHING`,
+		groupID, petID, userID)
+	if err != nil {
+		return false, fmt.Errorf("error voting in SQLite for group %s: %w", groupID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+func (s *sqliteStore) Shortlist(ctx context.Context, groupID string) ([]ShortlistEntry, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT s.pet_id, s.added_by, s.added_at, v.user_id
+		 FROM group_shortlist s LEFT JOIN group_votes v ON v.group_id = s.group_id AND v.pet_id = s.pet_id
+		 WHERE s.group_id = ? ORDER BY s.added_at`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting SQLite shortlist for group %s: %w", groupID, err)
+	}
+	defer rows.Close()
+
+	entries := []ShortlistEntry{}
+	index := make(map[int]int)
+	for rows.Next() {
+		var entry ShortlistEntry
+		var addedAt int64
+		var voter sql.NullString
+		if err := rows.Scan(&entry.PetID, &entry.AddedBy, &addedAt, &voter); err != nil {
+			return nil, err
+		}
+		i, ok := index[entry.PetID]
+		if !ok {
+			entry.AddedAt = time.Unix(0, addedAt)
+			i = len(entries)
+			index[entry.PetID] = i
+			entries = append(entries, entry)
+		}
+		if voter.Valid {
+			entries[i].Voters = append(entries[i].Voters, voter.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sortShortlist(entries)
+	return entries, nil
+}
+
+func (s *sqliteStore) RemoveFromShortlist(ctx context.Context, groupID string, petID int) error {
+	return s.deleteShortlist(ctx, `group_id = ? AND pet_id = ?`, groupID, petID)
+}
+
+func (s *sqliteStore) ClearShortlist(ctx context.Context, groupID string) error {
+	return s.deleteShortlist(ctx, `group_id = ?`, groupID)
+}
+
+// deleteShortlist deletes the shortlist entries and votes matching where.
+func (s *sqliteStore) deleteShortlist(ctx context.Context, where string, args ...interface{}) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"group_votes", "group_shortlist"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+where, args...); err != nil {
+			return fmt.Errorf("error removing from SQLite shortlist: %w", err)
+		}
+	}
+	return tx.Commit()
+}
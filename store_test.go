@@ -0,0 +1,305 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStores returns every backend that can run without external services.
+func testStores(t *testing.T) map[string]FavoritesStore {
+	sqlite, err := newSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]FavoritesStore{
+		"memory": newMemoryStore(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestFavoritesStore(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if favs, err := store.Favorites(ctx, "u1"); err != nil || len(favs) != 0 {
+				t.Fatalf("Expected no favorites, got %v %v", favs, err)
+			}
+
+			store.AddFavorite(ctx, "u1", 2)
+			store.AddFavorite(ctx, "u1", 1)
+			store.AddFavorite(ctx, "u1", 1)
+			store.AddFavorite(ctx, "u2", 3)
+
+			favs, err := store.Favorites(ctx, "u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(favs) != 2 {
+				t.Fatalf("Expected 2 favorites without duplicates, got %d", len(favs))
+			}
+			for _, fav := range favs {
+				if fav.AddedAt.IsZero() {
+					t.Errorf("Expected the time added to be recorded for pet %d", fav.PetID)
+				}
+			}
+		})
+	}
+}
+
+func TestSQLiteStoreMigratesLegacyFavorites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE favorites (user_id TEXT NOT NULL, pet_id INTEGER NOT NULL, pet TEXT NOT NULL, added_at INTEGER NOT NULL, PRIMARY KEY (user_id, pet_id));
+		INSERT INTO favorites VALUES ('u1', 7, '{"_id": 7}', 1)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := newSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddFavorite(context.Background(), "u1", 8); err != nil {
+		t.Fatal(err)
+	}
+	favs, err := store.Favorites(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favs) != 2 || favs[0].PetID != 8 || favs[1].PetID != 7 {
+		t.Errorf("Expected legacy favorites to be kept, got %+v", favs)
+	}
+}
+
+func TestSQLiteStoreMigratesFavoriteTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seconds.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Favourites used to be stamped in Unix seconds.
+	added := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, err = db.Exec(`CREATE TABLE favorites (user_id TEXT NOT NULL, pet_id INTEGER NOT NULL, added_at INTEGER NOT NULL, PRIMARY KEY (user_id, pet_id));
+		INSERT INTO favorites VALUES ('u1', 7, ?)`, added.Unix())
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := newSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddFavorite(context.Background(), "u1", 8); err != nil {
+		t.Fatal(err)
+	}
+	favs, err := store.Favorites(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(favs) != 2 || favs[0].PetID != 7 || !favs[0].AddedAt.Equal(added) {
+		t.Errorf("Expected the old favourite to keep its time, got %+v", favs)
+	}
+
+	// Opening the database again leaves converted times alone.
+	store.db.Close()
+	if store, err = newSQLiteStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if favs, _ := store.Favorites(context.Background(), "u1"); len(favs) != 2 || !favs[0].AddedAt.Equal(added) {
+		t.Errorf("Expected the migration to run once, got %+v", favs)
+	}
+}
+
+func TestFavoritesStoreOrderAndRemoval(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, id := range []int{1, 2, 3} {
+				store.AddFavorite(ctx, "u1", id)
+			}
+			store.AddFavorite(ctx, "u1", 1) // Re-adding keeps the original position
+			store.AddFavorite(ctx, "u2", 1)
+
+			favs, _ := store.Favorites(ctx, "u1")
+			if ids := petIDs(favs); len(ids) != 3 || ids[0] != 3 || ids[1] != 2 || ids[2] != 1 {
+				t.Errorf("Expected most recently added first, got %v", ids)
+			}
+
+			store.RemoveFavorite(ctx, "u1", 2)
+			favs, _ = store.Favorites(ctx, "u1")
+			if ids := petIDs(favs); len(ids) != 2 || ids[0] != 3 || ids[1] != 1 {
+				t.Errorf("Expected pet 2 to be removed, got %v", ids)
+			}
+
+			store.ClearFavorites(ctx, "u1")
+			if favs, _ := store.Favorites(ctx, "u1"); len(favs) != 0 {
+				t.Errorf("Expected no favorites after clearing, got %v", petIDs(favs))
+			}
+			if favs, _ := store.Favorites(ctx, "u2"); len(favs) != 1 {
+				t.Errorf("Clearing must not affect other users, got %v", petIDs(favs))
+			}
+		})
+	}
+}
+
+func petIDs(favs []Favorite) []int {
+	ids := make([]int, len(favs))
+	for i, fav := range favs {
+		ids[i] = fav.PetID
+	}
+	return ids
+}
+
+func TestAllFavoritesAndSettings(t *testing.T) {
+	ctx := context.Background()
+	sqlite, err := newSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]Store{"memory": newMemoryStore(), "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			store.AddFavorite(ctx, "u1", 1)
+			store.AddFavorite(ctx, "u2", 2)
+			all, err := store.AllFavorites(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != 2 || len(all["u1"]) != 1 || all["u2"][0].PetID != 2 {
+				t.Errorf("Expected favorites for both users, got %+v", all)
+			}
+
+			if muted, _ := store.NotificationsMuted(ctx, "u1"); muted {
+				t.Error("Expected notifications to be on by default")
+			}
+			store.SetNotificationsMuted(ctx, "u1", true)
+			if muted, _ := store.NotificationsMuted(ctx, "u1"); !muted {
+				t.Error("Expected notifications to be muted")
+			}
+			store.SetNotificationsMuted(ctx, "u1", false)
+			if muted, _ := store.NotificationsMuted(ctx, "u1"); muted {
+				t.Error("Expected notifications to be turned back on")
+			}
+		})
+	}
+}
+
+func TestSubscriptionStore(t *testing.T) {
+	ctx := context.Background()
+	sqlite, err := newSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]Store{"memory": newMemoryStore(), "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			dogs := SearchCriteria{Kind: "狗", Location: "臺北市"}
+			cats := SearchCriteria{Kind: "貓"}
+			store.AddSubscription(ctx, "u1", dogs)
+			store.AddSubscription(ctx, "u1", cats)
+			store.AddSubscription(ctx, "u1", dogs) // Duplicates are ignored
+			store.AddSubscription(ctx, "u2", cats)
+
+			subs, err := store.Subscriptions(ctx, "u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(subs) != 2 || subs[0].Criteria != dogs || subs[1].Criteria != cats {
+				t.Fatalf("Expected dogs then cats, got %+v", subs)
+			}
+
+			store.RemoveSubscription(ctx, "u1", subs[0].ID)
+			if subs, _ := store.Subscriptions(ctx, "u1"); len(subs) != 1 || subs[0].Criteria != cats {
+				t.Errorf("Expected only cats to remain, got %+v", subs)
+			}
+
+			all, err := store.AllSubscriptions(ctx)
+			if err != nil || len(all) != 2 {
+				t.Errorf("Expected subscriptions for both users, got %+v %v", all, err)
+			}
+
+			store.ClearSubscriptions(ctx, "u1")
+			if subs, _ := store.Subscriptions(ctx, "u1"); len(subs) != 0 {
+				t.Errorf("Expected no subscriptions after clearing, got %+v", subs)
+			}
+		})
+	}
+}
+
+func TestGroupStore(t *testing.T) {
+	ctx := context.Background()
+	sqlite, err := newSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]Store{"memory": newMemoryStore(), "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			store.AddToShortlist(ctx, "g1", 1, "alice")
+			store.AddToShortlist(ctx, "g1", 2, "bob")
+			store.AddToShortlist(ctx, "g1", 1, "bob") // Keeps alice as the one who added it
+			store.AddToShortlist(ctx, "g2", 1, "carol")
+
+			for _, vote := range []struct {
+				petID int
+				user  string
+				want  bool
+			}{
+				{2, "alice", true},
+				{2, "bob", true},
+				{2, "bob", false}, // One vote per member
+				{1, "carol", true},
+				{3, "alice", true}, // Voting adds the pet
+			} {
+				if counted, err := store.Vote(ctx, "g1", vote.petID, vote.user); err != nil || counted != vote.want {
+					t.Errorf("Vote(%d, %s) = %v %v, want %v", vote.petID, vote.user, counted, err, vote.want)
+				}
+			}
+
+			entries, err := store.Shortlist(ctx, "g1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 3 || entries[0].PetID != 2 || entries[1].PetID != 1 || entries[2].PetID != 3 {
+				t.Fatalf("Expected pets ordered by votes then time added, got %+v", entries)
+			}
+			if len(entries[0].Voters) != 2 || entries[1].AddedBy != "alice" {
+				t.Errorf("Unexpected entries %+v", entries)
+			}
+
+			store.RemoveFromShortlist(ctx, "g1", 2)
+			if entries, _ := store.Shortlist(ctx, "g1"); len(entries) != 2 || entries[0].PetID != 1 {
+				t.Errorf("Expected pet 2 to be removed, got %+v", entries)
+			}
+			store.AddToShortlist(ctx, "g1", 2, "bob")
+			if entries, _ := store.Shortlist(ctx, "g1"); len(entries) != 3 || entries[2].PetID != 2 || len(entries[2].Voters) != 0 {
+				t.Errorf("Expected votes to be removed with the pet, got %+v", entries)
+			}
+
+			store.ClearShortlist(ctx, "g1")
+			if entries, _ := store.Shortlist(ctx, "g1"); len(entries) != 0 {
+				t.Errorf("Expected an empty shortlist, got %+v", entries)
+			}
+			if entries, _ := store.Shortlist(ctx, "g2"); len(entries) != 1 {
+				t.Errorf("Clearing must not affect other groups, got %+v", entries)
+			}
+		})
+	}
+}
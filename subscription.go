@@ -0,0 +1,113 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	subscribeCommand          = "訂閱"
+	showSubscriptionsCommand  = "我的訂閱"
+	cancelSubscriptionCommand = "取消訂閱"
+	// maxSubscriptions limits how many saved searches each user can keep.
+	maxSubscriptions = 5
+)
+
+// describeCriteria returns a short human readable summary, e.g. "臺北市、母、小型、狗".
+func describeCriteria(c *SearchCriteria) string {
+	var parts []string
+	for _, v := range []string{c.Location, c.Age, c.Sex, c.BodyType, c.Color, c.Pattern, c.Kind} {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	if len(parts) == 0 {
+		return criteriaAny
+	}
+	return strings.Join(parts, "、")
+}
+
+// subscriptionList formats the user's subscriptions as a numbered list.
+func subscriptionList(subs []Subscription) string {
+	lines := []string{"您的訂閱："}
+	for i, sub := range subs {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, describeCriteria(&sub.Criteria)))
+	}
+	lines = append(lines, "", "輸入「取消訂閱 編號」取消其中一個，或「取消訂閱」全部取消。")
+	return strings.Join(lines, "\n")
+}
+
+// matchingPets returns the pets that match any of the subscriptions, at most
+// limit of them.
+func matchingPets(subs []Subscription, pets []Pet, limit int) []*Pet {
+	var matches []*Pet
+	for i := range pets {
+		for _, sub := range subs {
+			if sub.Criteria.Matches(&pets[i]) {
+				matches = append(matches, &pets[i])
+				break
+			}
+		}
+		if len(matches) >= limit {
+			break
+		}
+	}
+	return matches
+}
+
+// notifySubscriptionMatches queues a carousel of newly added pets for every
+// user with a matching subscription, skipping users who opted out.
+func notifySubscriptionMatches(ctx context.Context, subs SubscriptionStore, settings SettingsStore, added []Pet, batch *pushBatch) error {
+	if len(added) == 0 {
+		return nil
+	}
+	all, err := subs.AllSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Users with the same matches share one message, built once.
+	messages := make(map[string]linebot.SendingMessage)
+	for userID, userSubs := range all {
+		pets := matchingPets(userSubs, added, 10) // Carousel limit is 10
+		if len(pets) == 0 {
+			continue
+		}
+		muted, err := settings.NotificationsMuted(ctx, userID)
+		if err != nil {
+			log.Printf("Failed to read settings for %s: %v", userID, err)
+			continue
+		}
+		if muted {
+			continue
+		}
+
+		ids := make([]string, len(pets))
+		for i, pet := range pets {
+			ids[i] = strconv.Itoa(pet.ID)
+		}
+		key := "subscription:" + strings.Join(ids, ",")
+		if _, ok := messages[key]; !ok {
+			messages[key] = newCarouselMessage("有新的寵物符合您的訂閱", petBubbles(ctx, pets, createFavoriteButton))
+		}
+		batch.AddMessage(userID, key, messages[key])
+	}
+	return nil
+}
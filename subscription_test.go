@@ -0,0 +1,106 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+func TestCriteriaMatchesLocation(t *testing.T) {
+	pet := &Pet{Variety: "狗", Resettlement: "臺北市動物之家"}
+	for _, location := range []string{"台北", "臺北市", "台北市"} {
+		if !(&SearchCriteria{Kind: "狗", Location: location}).Matches(pet) {
+			t.Errorf("Expected %q to match %q", location, pet.Resettlement)
+		}
+	}
+	if (&SearchCriteria{Location: "新北市"}).Matches(pet) {
+		t.Error("Expected 新北市 not to match a Taipei shelter")
+	}
+}
+
+func TestCriteriaMatchesWords(t *testing.T) {
+	pet := &Pet{Variety: "狗", Sex: "F", Type: "SMALL", Age: "ADULT", Resettlement: "臺北市動物之家"}
+	// As Gemini returns them for 「訂閱 台北的小型母狗」.
+	if !(&SearchCriteria{Kind: "狗", Sex: "母", BodyType: "小型", Age: "成年", Location: "台北"}).Matches(pet) {
+		t.Error("Expected words to match the coded record")
+	}
+	if !(&SearchCriteria{Kind: "dog", Sex: "F", BodyType: "small"}).Matches(pet) {
+		t.Error("Expected codes to keep matching")
+	}
+	for _, c := range []SearchCriteria{{Sex: "公"}, {BodyType: "大型"}, {Age: "幼年"}} {
+		if c.Matches(pet) {
+			t.Errorf("Expected %+v not to match", c)
+		}
+	}
+
+	store := newMemoryStore()
+	store.AddSubscription(context.Background(), "U1", SearchCriteria{Kind: "狗", Sex: "母", BodyType: "小型"})
+	subs, err := store.Subscriptions(context.Background(), "U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := matchingPets(subs, []Pet{{ID: 1, Variety: "狗", Sex: "M", Type: "SMALL"}, *pet}, 5); len(got) != 1 || got[0].Variety != "狗" || got[0].Sex != "F" {
+		t.Errorf("Expected the subscription to match the small female dog, got %+v", got)
+	}
+}
+
+func TestDescribeCriteria(t *testing.T) {
+	c := &SearchCriteria{Kind: "狗", Sex: "母", BodyType: "小型", Location: "臺北市"}
+	if got := describeCriteria(c); got != "臺北市、母、小型、狗" {
+		t.Errorf("Unexpected description %q", got)
+	}
+}
+
+func TestNotifySubscriptionMatches(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	store.AddSubscription(ctx, "dogs1", SearchCriteria{Kind: "狗"})
+	store.AddSubscription(ctx, "dogs2", SearchCriteria{Kind: "狗"})
+	store.AddSubscription(ctx, "cats", SearchCriteria{Kind: "貓"})
+	store.AddSubscription(ctx, "muted", SearchCriteria{Kind: "狗"})
+	store.SetNotificationsMuted(ctx, "muted", true)
+	store.AddFavorite(ctx, "dogs1", 9)
+
+	added := []Pet{{ID: 1, Variety: "狗"}, {ID: 2, Variety: "狗"}}
+	pusher := &fakePusher{}
+	batch := newPushBatch(pusher, 10)
+	diff := &CatalogueDiff{Added: added, Removed: []Pet{{ID: 9, Name: "A009"}}}
+	notifyFavoriteChanges(ctx, store, store, diff, batch)
+	if err := notifySubscriptionMatches(ctx, store, store, added, batch); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := batch.Flush(ctx); err != nil || sent != 2 {
+		t.Fatalf("Expected both dog subscribers to be notified, got %d %v", sent, err)
+	}
+
+	// dogs1 also gets a favourite update, so the two users cannot share a multicast.
+	if len(pusher.calls) != 2 {
+		t.Fatalf("Expected 2 multicasts, got %d", len(pusher.calls))
+	}
+	for _, call := range pusher.calls {
+		last := call.messages[len(call.messages)-1]
+		flex, ok := last.(*linebot.FlexMessage)
+		if !ok {
+			t.Fatalf("Expected a carousel of new matches, got %T", last)
+		}
+		if n := len(flex.Contents.(*linebot.CarouselContainer).Contents); n != 2 {
+			t.Errorf("Expected 2 pets in the carousel, got %d", n)
+		}
+	}
+	if len(pusher.calls[0].messages) != 2 || pusher.calls[0].to[0] != "dogs1" {
+		t.Errorf("Expected dogs1 to get the favourite update and the carousel, got %+v", pusher.calls[0])
+	}
+}
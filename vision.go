@@ -0,0 +1,86 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// maxPhotoSize is the largest user photo we send to the vision model.
+const maxPhotoSize = 10 << 20
+
+// PetVision describes the pet in a photo as search criteria.
+type PetVision interface {
+	DescribePet(ctx context.Context, image []byte, mimeType string) (*SearchCriteria, error)
+}
+
+// petVision is nil when no vision backend is configured.
+var petVision PetVision
+
+type geminiVision struct {
+	model *geminiModel
+}
+
+const visionPrompt = `
+You are a pet adoption assistant. Look at the animal in this photo and describe it as search criteria.
+
+Identify the following criteria:
+- kind: "貓" or "狗"
+- body_type: "小型", "中型", or "大型"
+- color: the main coat colour, one of "白", "黑", "黃", "棕", "灰"
+- pattern: the coat pattern, one of "虎斑", "三花", "斑點", "雙色"
+
+Return the criteria as a JSON object. If a criterion cannot be seen in the photo, omit it from the JSON.
+For example, for a photo of a small black and white dog you should return:
+{
+  "kind": "狗",
+  "body_type": "小型",
+  "color": "黑",
+  "pattern": "雙色"
+}
+If the photo does not show a cat or a dog, return an empty JSON object {}.
+`
+
+// DescribePet asks Gemini to extract search criteria from a pet photo.
+func (v *geminiVision) DescribePet(ctx context.Context, image []byte, mimeType string) (*SearchCriteria, error) {
+	resp, err := v.model.GenerateContent(ctx, genai.Blob{MIMEType: mimeType, Data: image}, genai.Text(visionPrompt))
+	if err != nil {
+		return nil, err
+	}
+	return parseCriteriaResponse(resp), nil
+}
+
+// searchByPhoto describes the photo with vision and returns the pets that look
+// most alike, along with the criteria that were used.
+func searchByPhoto(ctx context.Context, vision PetVision, pets *Pets, image []byte, mimeType string) (*SearchCriteria, []*Pet, error) {
+	criteria, err := vision.DescribePet(ctx, image, mimeType)
+	if err != nil || criteria == nil {
+		return nil, nil, err
+	}
+	return criteria, findSimilarPets(pets, criteria), nil
+}
+
+// findSimilarPets searches with the given criteria and, when nothing matches,
+// drops the least important traits one by one until something does.
+func findSimilarPets(pets *Pets, criteria *SearchCriteria) []*Pet {
+	c := *criteria
+	relax := []*string{&c.Pattern, &c.Color, &c.BodyType}
+	for i := 0; ; i++ {
+		if found := pets.SearchPets(&c); len(found) > 0 || i == len(relax) {
+			return found
+		}
+		*relax[i] = ""
+	}
+}
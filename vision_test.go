@@ -0,0 +1,85 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+type fakeVision struct {
+	criteria *SearchCriteria
+	mimeType string
+}
+
+func (v *fakeVision) DescribePet(ctx context.Context, image []byte, mimeType string) (*SearchCriteria, error) {
+	v.mimeType = mimeType
+	return v.criteria, nil
+}
+
+func newTestPets() *Pets {
+	p := new(Pets)
+	p.LoadPets(TaiwanPets{
+		{AnimalID: 1, AnimalKind: "狗", AnimalSex: "M", AnimalBodytype: "SMALL", AnimalColour: "黑白色"},
+		{AnimalID: 2, AnimalKind: "貓", AnimalSex: "F", AnimalBodytype: "SMALL", AnimalColour: "虎斑色"},
+		{AnimalID: 3, AnimalKind: "貓", AnimalSex: "M", AnimalBodytype: "MEDIUM", AnimalColour: "白色"},
+	})
+	return p
+}
+
+func TestSearchByPhoto(t *testing.T) {
+	vision := &fakeVision{criteria: &SearchCriteria{Kind: "貓", Pattern: "虎斑"}}
+	criteria, pets, err := searchByPhoto(context.Background(), vision, newTestPets(), []byte("jpeg"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vision.mimeType != "image/jpeg" {
+		t.Errorf("Expected mime type to be passed through, got %q", vision.mimeType)
+	}
+	if criteria.Kind != "貓" {
+		t.Errorf("Expected cat criteria, got %+v", criteria)
+	}
+	if len(pets) != 1 || pets[0].ID != 2 {
+		t.Errorf("Expected the tabby cat, got %+v", pets)
+	}
+}
+
+func TestSearchByPhotoRelaxesCriteria(t *testing.T) {
+	vision := &fakeVision{criteria: &SearchCriteria{Kind: "狗", Color: "黃", Pattern: "斑點"}}
+	_, pets, err := searchByPhoto(context.Background(), vision, newTestPets(), nil, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pets) != 1 || pets[0].ID != 1 {
+		t.Errorf("Expected to fall back to any dog, got %+v", pets)
+	}
+}
+
+func TestSearchByPhotoMatchesBodyType(t *testing.T) {
+	// The vision prompt asks for sizes as words, the open data has codes.
+	vision := &fakeVision{criteria: &SearchCriteria{Kind: "貓", BodyType: "中型"}}
+	_, pets, err := searchByPhoto(context.Background(), vision, newTestPets(), nil, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pets) != 1 || pets[0].ID != 3 {
+		t.Errorf("Expected only the medium cat, got %+v", pets)
+	}
+}
+
+func TestSearchByPhotoNoPet(t *testing.T) {
+	criteria, pets, err := searchByPhoto(context.Background(), &fakeVision{}, newTestPets(), nil, "image/png")
+	if err != nil || criteria != nil || pets != nil {
+		t.Errorf("Expected no results for a photo without pets, got %+v %+v %v", criteria, pets, err)
+	}
+}
@@ -0,0 +1,273 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+const (
+	// multicastLimit is the most recipients LINE accepts per multicast call.
+	multicastLimit = 500
+	// maxNotificationLines keeps a notification well within LINE's text limit.
+	maxNotificationLines = 20
+	// maxPushMessages is the most messages LINE accepts per push.
+	maxPushMessages = 5
+)
+
+// Pusher sends push messages to users.
+type Pusher interface {
+	Multicast(ctx context.Context, to []string, messages ...linebot.SendingMessage) error
+}
+
+type linePusher struct {
+	client *linebot.Client
+}
+
+func (p *linePusher) Multicast(ctx context.Context, to []string, messages ...linebot.SendingMessage) error {
+	_, err := p.client.Multicast(to, messages...).WithContext(ctx).Do()
+	return err
+}
+
+// WatcherConfig controls the catalogue refresh loop.
+type WatcherConfig struct {
+	Interval  time.Duration // REFRESH_INTERVAL, 0 disables refreshing
+	PushQuota int           // PUSH_QUOTA_PER_REFRESH, recipients per refresh
+}
+
+// loadWatcherConfig reads the watcher settings, keeping the defaults for any
+// value that is missing or invalid.
+func loadWatcherConfig() WatcherConfig {
+	conf := WatcherConfig{Interval: time.Hour, PushQuota: 500}
+	if v := os.Getenv("REFRESH_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			conf.Interval = d
+		} else {
+			log.Printf("Warning: invalid REFRESH_INTERVAL %q: %v", v, err)
+		}
+	}
+	if v := os.Getenv("PUSH_QUOTA_PER_REFRESH"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			conf.PushQuota = n
+		} else {
+			log.Printf("Warning: invalid PUSH_QUOTA_PER_REFRESH %q, must be a positive number", v)
+		}
+	}
+	return conf
+}
+
+// watchCatalogue refreshes the catalogue every interval and notifies users
+// about changes until ctx is cancelled.
+func watchCatalogue(ctx context.Context, conf WatcherConfig, pusher Pusher) {
+	if conf.Interval <= 0 {
+		log.Println("Catalogue refresh is disabled.")
+		return
+	}
+	// The batch outlives each refresh so notifications held back by the push
+	// quota are delivered on the next one.
+	batch := newPushBatch(pusher, conf.PushQuota)
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refreshCatalogue(ctx, batch)
+		}
+	}
+}
+
+func refreshCatalogue(ctx context.Context, batch *pushBatch) {
+	diff, err := PetDB.Refresh()
+	if err != nil {
+		log.Printf("Failed to refresh pets: %v", err)
+		return
+	}
+	go validatePhotos(ctx, photos, PetDB.All())
+
+	if err := notifyFavoriteChanges(ctx, favoriteStore, settingsStore, diff, batch); err != nil {
+		log.Printf("Failed to collect favorite changes: %v", err)
+	}
+	if err := notifySubscriptionMatches(ctx, subscriptionStore, settingsStore, diff.Added, batch); err != nil {
+		log.Printf("Failed to collect subscription matches: %v", err)
+	}
+	sent, err := batch.Flush(ctx)
+	if err != nil {
+		log.Printf("Failed to push notifications: %v", err)
+	}
+	log.Printf("Pushed notifications to %d users", sent)
+}
+
+// notifyFavoriteChanges queues a notification for every user whose favourites
+// were adopted, moved or updated, skipping users who opted out.
+func notifyFavoriteChanges(ctx context.Context, favs FavoritesStore, settings SettingsStore, diff *CatalogueDiff, batch *pushBatch) error {
+	news := make(map[int]string)
+	for _, pet := range diff.Removed {
+		news[pet.ID] = fmt.Sprintf("「%s」（%s）已不在認養名單中，可能已經找到新家了！", pet.Name, pet.Variety)
+	}
+	for _, change := range diff.Changed {
+		pet := change.New
+		switch {
+		case change.StatusChanged():
+			news[pet.ID] = fmt.Sprintf("「%s」的狀態變更為%s。", pet.Name, pet.StatusText())
+		case change.ShelterChanged():
+			news[pet.ID] = fmt.Sprintf("「%s」已移到%s。", pet.Name, pet.Resettlement)
+		default:
+			news[pet.ID] = fmt.Sprintf("「%s」的資料有更新。", pet.Name)
+		}
+	}
+	if len(news) == 0 {
+		return nil
+	}
+
+	all, err := favs.AllFavorites(ctx)
+	if err != nil {
+		return err
+	}
+	for userID, userFavs := range all {
+		var lines []string
+		for _, fav := range userFavs {
+			if line, ok := news[fav.PetID]; ok {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		muted, err := settings.NotificationsMuted(ctx, userID)
+		if err != nil {
+			log.Printf("Failed to read settings for %s: %v", userID, err)
+			continue
+		}
+		if muted {
+			continue
+		}
+		batch.Add(userID, favoriteNotification(lines))
+	}
+	return nil
+}
+
+// favoriteNotification combines a user's favourite updates into one message.
+func favoriteNotification(lines []string) string {
+	if len(lines) > maxNotificationLines {
+		more := len(lines) - maxNotificationLines
+		lines = append(lines[:maxNotificationLines:maxNotificationLines], fmt.Sprintf("…還有 %d 則更新", more))
+	}
+	return "您收藏的寵物有新消息：\n\n" + strings.Join(lines, "\n") +
+		"\n\n輸入「收藏」查看最新資料，輸入「關閉通知」即可停止通知。"
+}
+
+// pushBatch collects messages per user and sends them with as few API calls as
+// possible, pushing to at most budget users per flush.
+type pushBatch struct {
+	pusher   Pusher
+	budget   int
+	messages map[string][]linebot.SendingMessage
+	keys     map[string][]string
+	// since is the flush each user has been waiting since, so users held
+	// back by the budget go first next time.
+	since map[string]int
+	round int
+}
+
+func newPushBatch(pusher Pusher, budget int) *pushBatch {
+	return &pushBatch{
+		pusher:   pusher,
+		budget:   budget,
+		messages: make(map[string][]linebot.SendingMessage),
+		keys:     make(map[string][]string),
+		since:    make(map[string]int),
+	}
+}
+
+// Add queues a text message for userID.
+func (b *pushBatch) Add(userID, text string) {
+	b.AddMessage(userID, text, linebot.NewTextMessage(text))
+}
+
+// AddMessage queues a message for userID. Users whose queued messages have the
+// same keys receive the same messages and are sent together in one multicast.
+// LINE accepts at most five messages per push; later ones are dropped.
+func (b *pushBatch) AddMessage(userID, key string, message linebot.SendingMessage) {
+	if len(b.messages[userID]) >= maxPushMessages {
+		log.Printf("Dropping push message for %s: too many messages", userID)
+		return
+	}
+	if _, ok := b.since[userID]; !ok {
+		b.since[userID] = b.round
+	}
+	b.messages[userID] = append(b.messages[userID], message)
+	b.keys[userID] = append(b.keys[userID], key)
+}
+
+// Flush sends the queued messages and returns how many users were reached.
+// Users beyond the budget stay queued and are sent first on the next flush.
+func (b *pushBatch) Flush(ctx context.Context) (int, error) {
+	defer func() { b.round++ }()
+	users := make([]string, 0, len(b.messages))
+	for userID := range b.messages {
+		users = append(users, userID)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if b.since[users[i]] != b.since[users[j]] {
+			return b.since[users[i]] < b.since[users[j]]
+		}
+		return users[i] < users[j]
+	})
+	if len(users) > b.budget {
+		log.Printf("Push quota reached, holding notifications for %d users until the next refresh", len(users)-b.budget)
+		users = users[:b.budget]
+	}
+
+	groups := make(map[string][]string)
+	var order []string
+	for _, userID := range users {
+		key := strings.Join(b.keys[userID], "\x00")
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], userID)
+	}
+
+	sent := 0
+	for _, key := range order {
+		to := groups[key]
+		messages := b.messages[to[0]]
+		for start := 0; start < len(to); start += multicastLimit {
+			end := start + multicastLimit
+			if end > len(to) {
+				end = len(to)
+			}
+			if err := b.pusher.Multicast(ctx, to[start:end], messages...); err != nil {
+				return sent, err
+			}
+			for _, userID := range to[start:end] {
+				delete(b.messages, userID)
+				delete(b.keys, userID)
+				delete(b.since, userID)
+			}
+			sent += end - start
+		}
+	}
+	return sent, nil
+}
@@ -0,0 +1,149 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v7/linebot"
+)
+
+type pushCall struct {
+	to       []string
+	messages []linebot.SendingMessage
+}
+
+type fakePusher struct {
+	calls []pushCall
+}
+
+func (p *fakePusher) Multicast(ctx context.Context, to []string, messages ...linebot.SendingMessage) error {
+	p.calls = append(p.calls, pushCall{to: append([]string{}, to...), messages: messages})
+	return nil
+}
+
+// pushedText returns the text pushed to userID, or "" if nothing was sent.
+func (p *fakePusher) pushedText(userID string) string {
+	for _, call := range p.calls {
+		for _, to := range call.to {
+			if to != userID {
+				continue
+			}
+			if msg, ok := call.messages[0].(*linebot.TextMessage); ok {
+				return msg.Text
+			}
+		}
+	}
+	return ""
+}
+
+func TestNotifyFavoriteChanges(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	store.AddFavorite(ctx, "adopter", 1)
+	store.AddFavorite(ctx, "mover", 2)
+	store.AddFavorite(ctx, "muted", 1)
+	store.AddFavorite(ctx, "quiet", 9)
+	store.SetNotificationsMuted(ctx, "muted", true)
+
+	diff := &CatalogueDiff{
+		Removed: []Pet{{ID: 1, Name: "A001", Variety: "狗"}},
+		Changed: []PetChange{{Old: Pet{ID: 2, Name: "B002", Resettlement: "舊"}, New: Pet{ID: 2, Name: "B002", Resettlement: "新收容所"}}},
+	}
+	pusher := &fakePusher{}
+	batch := newPushBatch(pusher, 10)
+	if err := notifyFavoriteChanges(ctx, store, store, diff, batch); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := batch.Flush(ctx); err != nil || sent != 2 {
+		t.Fatalf("Expected 2 users to be notified, got %d %v", sent, err)
+	}
+
+	if text := pusher.pushedText("adopter"); !strings.Contains(text, "A001") {
+		t.Errorf("Expected an adoption notice, got %q", text)
+	}
+	if text := pusher.pushedText("mover"); !strings.Contains(text, "新收容所") {
+		t.Errorf("Expected a shelter change notice, got %q", text)
+	}
+	if text := pusher.pushedText("muted"); text != "" {
+		t.Errorf("Muted users must not be notified, got %q", text)
+	}
+	if text := pusher.pushedText("quiet"); text != "" {
+		t.Errorf("Users without changes must not be notified, got %q", text)
+	}
+}
+
+func TestPushBatchGroupsAndRespectsBudget(t *testing.T) {
+	pusher := &fakePusher{}
+	batch := newPushBatch(pusher, 3)
+	batch.Add("u1", "same")
+	batch.Add("u2", "same")
+	batch.Add("u3", "other")
+	batch.Add("u4", "same")
+
+	sent, err := batch.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 3 {
+		t.Errorf("Expected the budget to cap recipients at 3, got %d", sent)
+	}
+	if len(pusher.calls) != 2 {
+		t.Fatalf("Expected identical messages to share a multicast, got %d calls", len(pusher.calls))
+	}
+	if len(pusher.calls[0].to) != 2 {
+		t.Errorf("Expected u1 and u2 in one multicast, got %v", pusher.calls[0].to)
+	}
+}
+
+func TestPushBatchCarriesOverUsersBeyondBudget(t *testing.T) {
+	pusher := &fakePusher{}
+	batch := newPushBatch(pusher, 2)
+	for _, userID := range []string{"u1", "u2", "u3"} {
+		batch.Add(userID, "first")
+	}
+	if sent, err := batch.Flush(context.Background()); err != nil || sent != 2 {
+		t.Fatalf("Expected 2 users in the first flush, got %d %v", sent, err)
+	}
+
+	// u3 was held back, so it goes before users queued since.
+	batch.Add("u1", "second")
+	batch.Add("u0", "second")
+	pusher.calls = nil
+	if sent, err := batch.Flush(context.Background()); err != nil || sent != 2 {
+		t.Fatalf("Expected 2 users in the second flush, got %d %v", sent, err)
+	}
+	var reached []string
+	for _, call := range pusher.calls {
+		reached = append(reached, call.to...)
+	}
+	if len(reached) != 2 || reached[0] != "u3" || reached[1] != "u0" {
+		t.Errorf("Expected u3 and then u0, got %v", reached)
+	}
+
+	pusher.calls = nil
+	if sent, _ := batch.Flush(context.Background()); sent != 1 || pusher.calls[0].to[0] != "u1" {
+		t.Errorf("Expected u1 to be delivered on the third flush, got %d %+v", sent, pusher.calls)
+	}
+}
+
+func TestLoadWatcherConfigPushQuota(t *testing.T) {
+	for v, want := range map[string]int{"": 500, "20": 20, "0": 500, "-1": 500, "many": 500} {
+		t.Setenv("PUSH_QUOTA_PER_REFRESH", v)
+		if got := loadWatcherConfig().PushQuota; got != want {
+			t.Errorf("PUSH_QUOTA_PER_REFRESH=%q: got %d, want %d", v, got, want)
+		}
+	}
+}